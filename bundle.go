@@ -8,7 +8,11 @@ import (
 	"go/types"
 	"io"
 	"log"
-	"strconv"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
 
 	"golang.org/x/tools/go/packages"
 	"golang.org/x/tools/imports"
@@ -16,14 +20,43 @@ import (
 
 // BundleOption defines the options for the Bundle processor.
 type BundleOption struct {
-	Log     *log.Logger
-	Pkg     string            // Package to be processed
-	NewPkg  string            // Name of the resulting package (default=current working dir package)
-	Prefix  string            // Prefix for the global identifiers (default=packageName_)
-	Types   map[string]string // Map the names of the types to be renamed to their new one
-	RmTypes map[string]bool   // Named types to be removed
-	Const   map[string]int    // Values for const to be updated
-	RmConst map[string]bool   // Constants to be removed
+	Log          *log.Logger       `json:"-"`
+	Pkg          string            // Package to be processed
+	NewPkg       string            // Name of the resulting package (default=current working dir package)
+	Prefix       string            // Prefix for the global identifiers (default=packageName_)
+	Types        map[string]string // Map the names of the types to be renamed to their new one
+	RmTypes      map[string]bool   // Named types to be removed
+	Const        map[string]string // Go constant expressions for const to be updated
+	RmConst      map[string]bool   // Constants to be removed
+	RmFuncs      map[string]bool   // Functions to be removed, re: prefixing regular expressions (e.g. re:^debug)
+	RmVars       map[string]bool   // Variables to be removed, re: prefixing regular expressions
+	RmMethods    map[string]bool   // Methods to be removed as Type.Method, re: prefixing regular expressions
+	Roots        map[string]bool   // Only keep the declarations reachable from these (default=keep all)
+	TypeArgs     map[string]string // Instantiate the generic declarations with these type arguments, keyed by type parameter name
+	Rewrite      []string          // gofmt -r style rules 'pattern -> replacement' applied after renaming
+	Inline       []string          // Import path patterns of the dependencies to be bundled too (e.g. example.com/mod/internal/...)
+	CheckFile    string            // Type check the result as this file of its package before writing it (default=no check)
+	Overlay      map[string][]byte `json:"-"` // New content of the other generated files, keyed by path, type checked along CheckFile
+	Lines        bool              // Emit //line directives pointing to the declarations in the package
+	OutDir       string            // Directory of the output file the //line directives are relative to (default=CheckFile directory)
+	Header       string            // Written before the package clause and type checked along the code, e.g. a generated code notice
+	Transformers []Transformer     `json:"-"` // Transformations applied to each instance after the built-in ones
+	Instances    []BundleInstance  // Instantiations of the package, unset fields default to the ones above
+}
+
+// BundleInstance defines the options specific to one instantiation of the bundled package.
+type BundleInstance struct {
+	Prefix    string            // Prefix for the global identifiers (default=packageName_)
+	Types     map[string]string // Map the names of the types to be renamed to their new one
+	RmTypes   map[string]bool   // Named types to be removed
+	Const     map[string]string // Go constant expressions for const to be updated
+	RmConst   map[string]bool   // Constants to be removed
+	RmFuncs   map[string]bool   // Functions to be removed, re: prefixing regular expressions (e.g. re:^debug)
+	RmVars    map[string]bool   // Variables to be removed, re: prefixing regular expressions
+	RmMethods map[string]bool   // Methods to be removed as Type.Method, re: prefixing regular expressions
+	Roots     map[string]bool   // Only keep the declarations reachable from these (default=keep all)
+	TypeArgs  map[string]string // Instantiate the generic declarations with these type arguments, keyed by type parameter name
+	Rewrite   []string          // gofmt -r style rules 'pattern -> replacement' applied after renaming
 }
 
 // newpkgname returns the set value or a default one.
@@ -34,213 +67,480 @@ func (o *BundleOption) newpkgname() (string, error) {
 	return localPkgName()
 }
 
+// instances returns the set instances or the one defined by the options.
+func (o *BundleOption) instances() []BundleInstance {
+	if len(o.Instances) == 0 {
+		return []BundleInstance{{
+			Prefix:    o.Prefix,
+			Types:     o.Types,
+			RmTypes:   o.RmTypes,
+			Const:     o.Const,
+			RmConst:   o.RmConst,
+			RmFuncs:   o.RmFuncs,
+			RmVars:    o.RmVars,
+			RmMethods: o.RmMethods,
+			Roots:     o.Roots,
+			TypeArgs:  o.TypeArgs,
+			Rewrite:   o.Rewrite,
+		}}
+	}
+	insts := make([]BundleInstance, len(o.Instances))
+	for i, inst := range o.Instances {
+		if inst.Prefix == "" {
+			inst.Prefix = o.Prefix
+		}
+		if inst.Types == nil {
+			inst.Types = o.Types
+		}
+		if inst.RmTypes == nil {
+			inst.RmTypes = o.RmTypes
+		}
+		if inst.Const == nil {
+			inst.Const = o.Const
+		}
+		if inst.RmConst == nil {
+			inst.RmConst = o.RmConst
+		}
+		if inst.RmFuncs == nil {
+			inst.RmFuncs = o.RmFuncs
+		}
+		if inst.RmVars == nil {
+			inst.RmVars = o.RmVars
+		}
+		if inst.RmMethods == nil {
+			inst.RmMethods = o.RmMethods
+		}
+		if inst.Roots == nil {
+			inst.Roots = o.Roots
+		}
+		if inst.TypeArgs == nil {
+			inst.TypeArgs = o.TypeArgs
+		}
+		if inst.Rewrite == nil {
+			inst.Rewrite = o.Rewrite
+		}
+		insts[i] = inst
+	}
+	return insts
+}
+
 // prefix returns the set value or a default one.
-func (o *BundleOption) prefix(pkg *packages.Package) string {
+func (o *BundleInstance) prefix(pkg *packages.Package) string {
 	if o.Prefix != "" {
 		return o.Prefix
 	}
 	// Use the source package name as the prefix.
-	return pkg.Name + "_"
+	return strings.TrimSuffix(pkg.Name, "_test") + "_"
 }
 
 // Bundle packs the package identified by o.PkgName into a bundle file and writes it to the given io.Writer.
+// Each instance is written in turn, the package only being loaded once. Subsequent calls
+// for the same package reuse the loaded package.
 func Bundle(out io.Writer, o BundleOption) error {
+	return bundle(out, o, false)
+}
+
+// BundleTests is similar to Bundle but only packs the test files of the package, so that
+// the result can be used as the test file for the one produced by Bundle with the same options.
+//
+// The external test package is merged into the new package, its references to the
+// bundled package being replaced by the prefixed declarations.
+// Test, benchmark, example and fuzz functions are renamed by inserting the capitalized prefix
+// after their Test, Benchmark, Example or Fuzz prefix (e.g. TestMin becomes TestInt64Min).
+// TestMain is discarded and roots are ignored.
+func BundleTests(out io.Writer, o BundleOption) error {
+	return bundle(out, o, true)
+}
+
+func bundle(out io.Writer, o BundleOption, tests bool) error {
 	if o.Log != nil {
 		o.Log.Printf("Options: %#v\n", o)
 		o.Log.Printf("Loading packages with %v\n", o.Pkg)
 	}
-	pkgs, err := loadPkg(o.Pkg)
+	load := loadPkg
+	if tests {
+		load = loadTestPkg
+	}
+	pkgs, err := load(o.Pkg)
 	if err != nil {
 		return err
 	}
 	if packages.PrintErrors(pkgs) > 0 {
 		return fmt.Errorf("too many errors while loading package %s", o.Pkg)
 	}
+	if tests {
+		pkgs = testPkgs(pkgs)
+		if len(pkgs) == 0 {
+			return fmt.Errorf("no test files in package %s", o.Pkg)
+		}
+	}
+	// Dependencies to be inlined.
+	inlined := map[*packages.Package]bool{}
+	if len(o.Inline) > 0 {
+		for _, pkg := range inlinedPkgs(pkgs, o.Inline) {
+			inlined[pkg] = true
+			pkgs = append(pkgs, pkg)
+		}
+	}
 	if o.Log != nil {
 		o.Log.Printf("Found %d packages: %v\n", len(pkgs), pkgs)
 	}
 
-	// Build the list of identifiers NOT to be renamed:
-	// - renamed types
-	// - removed constants
-	// - removed types
-	ignore := make(map[string]bool)
-	for src, tgt := range o.Types {
-		if o.RmTypes[src] {
-			// Make sure that renamed types that need to be removed are also in the rm list.
-			o.RmTypes[tgt] = true
+	// Build the bundle file package.
+	dir := o.OutDir
+	if dir == "" {
+		dir = filepath.Dir(o.CheckFile)
+	}
+	body := output{lines: o.Lines, dir: dir}
+	typeImports := map[string]string{}
+	for _, inst := range o.instances() {
+		inst.Types, err = typeExprs(inst.Types, typeImports)
+		if err != nil {
+			return err
+		}
+		inst.TypeArgs, err = typeExprs(inst.TypeArgs, typeImports)
+		if err != nil {
+			return err
+		}
+		if err := bundleInstance(&body, pkgs, inlined, o.Log, inst, typeImports, o.Transformers, tests); err != nil {
+			return err
 		}
 	}
-	for src := range o.RmConst {
-		ignore[src] = true
+	var buf bytes.Buffer
+	newName, err := o.newpkgname()
+	if err != nil {
+		return err
 	}
-	for src := range o.RmTypes {
-		ignore[src] = true
+	_, err = fmt.Fprintf(&buf, "%spackage %s\n\n", o.Header, newName)
+	if err != nil {
+		return err
+	}
+	// Imports required by the types mapping.
+	paths := make([]string, 0, len(typeImports))
+	for path := range typeImports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if name := typeImports[path]; name != pathpkg.Base(path) {
+			_, err = fmt.Fprintf(&buf, "import %s %q\n", name, path)
+		} else {
+			_, err = fmt.Fprintf(&buf, "import %q\n", path)
+		}
+		if err != nil {
+			return err
+		}
 	}
+	_, _ = body.WriteTo(&buf)
+
+	// Resolve imports and format the resulting code.
 	if o.Log != nil {
-		o.Log.Printf("No prefix: %v", keysOf(ignore))
+		o.Log.Printf("Resolving imports\n")
 	}
-	// Rename types in all packages.
-	renameID, renameDone := renamer()
-	defer renameDone()
+	code, err := imports.Process("", buf.Bytes(), nil)
+	if err != nil {
+		// Output without imports.
+		_, _ = io.Copy(out, &buf)
+		return err
+	}
+	if o.CheckFile != "" {
+		if o.Log != nil {
+			o.Log.Printf("Type checking %s\n", o.CheckFile)
+		}
+		if err := checkCode(o.CheckFile, code, tests, body.origins, o.Overlay); err != nil {
+			return err
+		}
+	}
+	_, err = io.Copy(out, bytes.NewReader(code))
 
-	objsToUpdate := map[types.Object]bool{}
+	return err
+}
+
+// checkCollisions returns an error if prefixed declarations of different packages have the same name.
+func checkCollisions(pkgs []*packages.Package, prefix func(*types.Package) string, objsToUpdate map[types.Object]bool) error {
+	names := map[string]*types.Package{}
 	for _, pkg := range pkgs {
-		if o.Log != nil {
-			o.Log.Printf("Renaming types in %v\n", pkg)
+		scope := pkg.Types.Scope()
+		for _, name := range scope.Names() {
+			obj := scope.Lookup(name)
+			if !objsToUpdate[obj] {
+				continue
+			}
+			newname := prefix(pkg.Types) + name
+			if p, ok := names[newname]; ok && p != pkg.Types {
+				return fmt.Errorf("%s declared in both %s and %s", newname, p.Path(), pkg.Types.Path())
+			}
+			names[newname] = pkg.Types
 		}
-		renamePkg(pkg, o.Types, ignore, objsToUpdate, renameID)
 	}
+	return nil
+}
 
-	// Prefix global declarations in all packages.
+// testPkgs returns the packages required to bundle the tests: the package under test
+// (its test variant if any) and the external test package if any.
+// It returns nil if there are no test files.
+func testPkgs(pkgs []*packages.Package) []*packages.Package {
+	// Packages superseded by their test variant, e.g. "p [p.test]".
+	variants := map[string]bool{}
 	for _, pkg := range pkgs {
-		if o.Log != nil {
-			o.Log.Printf("Prefixing types in %v\n", pkg)
+		if i := strings.Index(pkg.ID, " ["); i > 0 && !strings.HasSuffix(pkg.Name, "_test") {
+			variants[pkg.ID[:i]] = true
 		}
-		prefixPkg(pkg, o.prefix(pkg), objsToUpdate, renameID)
 	}
+	var res []*packages.Package
+	var hasTests bool
+	for _, pkg := range pkgs {
+		if strings.HasSuffix(pkg.ID, ".test") || variants[pkg.ID] {
+			// Skip the generated test main package and the superseded packages.
+			continue
+		}
+		res = append(res, pkg)
+		for _, f := range pkg.Syntax {
+			hasTests = hasTests || isTestFile(pkg, f)
+		}
+	}
+	if !hasTests {
+		return nil
+	}
+	return res
+}
 
-	// Build the bundle file package.
-	var buf bytes.Buffer
-	newName, err := o.newpkgname()
+// isTestFile reports whether the file is a test one.
+func isTestFile(pkg *packages.Package, f *ast.File) bool {
+	return strings.HasSuffix(pkg.Fset.File(f.Pos()).Name(), "_test.go")
+}
+
+// testName returns the name of the test function once prefixed.
+// It returns false if name is not the one of a test function.
+func testName(prefix, name string) (string, bool) {
+	for _, kind := range []string{"Test", "Benchmark", "Example", "Fuzz"} {
+		if !strings.HasPrefix(name, kind) {
+			continue
+		}
+		if rest := name[len(kind):]; rest == "" || !unicode.IsLower(rune(rest[0])) {
+			return kind + strings.ToUpper(prefix[:1]) + prefix[1:] + rest, true
+		}
+	}
+	return "", false
+}
+
+// bundleInstance writes the declarations of the packages for the given instance.
+// The inlined packages are the dependencies bundled with the packages.
+// The type expressions of the instance refer to packages by the name given in imports, keyed by import path.
+// The transformers are applied after the built-in ones.
+// If tests is set, only the declarations of the test files are written.
+// The packages are restored to their original state once done.
+func bundleInstance(buf *output, pkgs []*packages.Package, inlined map[*packages.Package]bool,
+	logger *log.Logger, o BundleInstance, imports map[string]string, transformers []Transformer, tests bool) error {
+	if logger != nil {
+		logger.Printf("Instance: %#v\n", o)
+	}
+	dirs, err := parseDirectives(pkgs)
 	if err != nil {
 		return err
 	}
-	_, err = fmt.Fprintf(&buf, "package %s\n\n", newName)
-	if err != nil {
+	if err := dirs.checkConsts(o.Const); err != nil {
 		return err
 	}
-	//TODO test package is missing?
+	// The renamed types must be declared by the package, e.g. not be misspelled options.
+	var missing []string
+	for src := range o.Types {
+		if _, ok := pkgs[0].Types.Scope().Lookup(src).(*types.TypeName); !ok {
+			missing = append(missing, src)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("types not found: %v", missing)
+	}
+	rmTypes := make(map[string]bool, len(o.RmTypes))
+	for src := range o.RmTypes {
+		rmTypes[src] = true
+	}
+	for src, tgt := range o.Types {
+		switch {
+		case !token.IsIdentifier(tgt) || types.Universe.Lookup(tgt) != nil:
+			// Types renamed to a type expression or a predeclared type cannot be declared,
+			// the target being left alone.
+			rmTypes[src] = true
+		case o.RmTypes[src] || dirs.pivots[src]:
+			// Pivot types are replaced by their new name.
+			rmTypes[src] = true
+			// Make sure that renamed types that need to be removed are also in the rm list.
+			rmTypes[tgt] = true
+		}
+	}
+	if len(o.TypeArgs) > 0 {
+		// Constraints are not used once the generic declarations are instantiated.
+		for _, name := range constraintTypes(pkgs) {
+			rmTypes[name] = true
+		}
+	}
+	o.RmTypes = rmTypes
+
+	// Prefix global declarations in all packages, the inlined ones
+	// with their name following the instance prefix.
+	prefixes := map[*types.Package]string{}
 	for _, pkg := range pkgs {
-		if o.Log != nil {
-			o.Log.Printf("Writing package %v\n", pkg)
+		if inlined[pkg] {
+			prefixes[pkg.Types] = o.prefix(pkgs[0]) + pkg.Name + "_"
+		} else {
+			prefixes[pkg.Types] = o.prefix(pkg)
+		}
+	}
+	t := newTransformation(pkgs, func(p *types.Package) string { return prefixes[p] }, logger, tests)
+	defer t.done()
+	t.lines = buf.lines
+
+	builtins := []Transformer{
+		Conditionals(o.Types, o.Const),
+		dirs,
+		// Removed types and constants, as well as renamed types, are not prefixed.
+		RemoveTypes(o.RmTypes),
+		RemoveConsts(o.RmConst),
+		RemoveDecls(o.RmFuncs, o.RmVars, o.RmMethods),
+		RenameTypes(o.Types),
+		TransformerFunc(prefixDecls),
+		TransformerFunc(func(t *Transformation) error {
+			// Instantiate the generic declarations.
+			done, err := instantiate(t.Pkgs, o.TypeArgs, imports, t.Rename)
+			if err != nil {
+				return err
+			}
+			t.Defer(done)
+			return nil
+		}),
+		TransformerFunc(renameTests),
+		TransformerFunc(unqualifyPkgs),
+		UpdateConsts(o.Const),
+		RewriteRules(o.Rewrite),
+	}
+	for _, tr := range append(builtins, transformers...) {
+		if err := tr.Transform(t); err != nil {
+			return err
+		}
+	}
+
+	// Only keep the declarations reachable from the roots, if any.
+	var live map[types.Object]bool
+	if len(o.Roots) > 0 && !tests {
+		live, err = reachable(pkgs, o.Roots, o.RmTypes)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, pkg := range pkgs {
+		if logger != nil {
+			logger.Printf("Writing package %v\n", pkg)
 		}
 		for _, f := range pkg.Syntax {
-		next:
+			if isTestFile(pkg, f) != tests {
+				continue
+			}
 			for _, decl := range f.Decls {
-				switch decl := decl.(type) {
-				case *ast.GenDecl:
-					switch decl.Tok {
-					case token.IMPORT:
-						// Skip imports.
-						continue
-					case token.CONST:
-						// Constants to be updated or removed.
-						if len(o.Const) == 0 && len(o.RmConst) == 0 && len(o.RmTypes) == 0 {
-							break
-						}
-						for _, spec := range decl.Specs {
-							v, ok := spec.(*ast.ValueSpec)
-							if !ok {
-								continue
-							}
-							if ident, ok := v.Type.(*ast.Ident); ok {
-								// Typed constant: remove if its type is to be removed.
-								if name := ident.Name; o.RmTypes[name] {
-									if o.Log != nil {
-										o.Log.Printf("const of type %s discarded", name)
-									}
-									continue next
-								}
-							}
-							if len(v.Values) == 0 {
-								// initial values; or nil
-								continue
-							}
-							// Do not print out the constant if it is defined standalone.
-							if len(v.Names) == 1 {
-								if name := v.Names[0].Name; o.RmConst[name] {
-									// Constant to be completely removed.
-									if o.Log != nil {
-										o.Log.Printf("const %s discarded", name)
-									}
-									continue next
-								}
-								continue
-							}
-							// If more than one constant, ignore its line (might be part of iota?).
-							for i, id := range v.Names {
-								name := id.Name
-								if o.RmConst[name] {
-									// Constant to be ignored.
-									id.Name = "_"
-									if o.Log != nil {
-										o.Log.Printf("const %s ignored", name)
-									}
-									continue
-								}
-								lit, ok := v.Values[i].(*ast.BasicLit)
-								if !ok {
-									continue
-								}
-								if n, ok := o.Const[name]; ok {
-									// Update the constant value.
-									val := lit.Value
-									lit.Value = strconv.Itoa(n)
-									if o.Log != nil {
-										o.Log.Printf("const %s value updated from %s to %s", name, val, lit.Value)
-									}
-								}
+				if t.Removed(decl) {
+					continue
+				}
+				if d, ok := decl.(*ast.GenDecl); ok && d.Tok == token.IMPORT {
+					// Skip imports.
+					continue
+				}
+				if live != nil {
+					switch decl := decl.(type) {
+					case *ast.FuncDecl:
+						if !live[pkg.TypesInfo.Defs[decl.Name]] {
+							if logger != nil {
+								logger.Printf("func %s unreachable", decl.Name.Name)
 							}
+							continue
 						}
-					case token.TYPE:
-						if len(o.RmTypes) == 0 {
-							break
+					case *ast.GenDecl:
+						specs := liveSpecs(pkg.TypesInfo, decl, live)
+						if len(specs) == 0 {
+							continue
 						}
-						for _, spec := range decl.Specs {
-							t, ok := spec.(*ast.TypeSpec)
-							if !ok {
-								continue
+						if len(specs) < len(decl.Specs) {
+							// Only print the reachable specs.
+							defer func(decl *ast.GenDecl, specs []ast.Spec, lparen token.Pos) {
+								decl.Specs, decl.Lparen = specs, lparen
+							}(decl, decl.Specs, decl.Lparen)
+							decl.Specs = specs
+							if len(specs) == 1 && decl.Tok != token.CONST {
+								decl.Lparen = token.NoPos
 							}
-							if name := t.Name.Name; o.RmTypes[name] {
-								// Type to be removed.
-								if o.Log != nil {
-									o.Log.Printf("type %s discarded", name)
-								}
-								continue next
-							}
-						}
-					}
-				case *ast.FuncDecl:
-					if len(o.RmTypes) == 0 || decl.Recv == nil {
-						break
-					}
-					var id *ast.Ident
-					switch t := decl.Recv.List[0].Type.(type) {
-					case *ast.StarExpr:
-						id = t.X.(*ast.Ident)
-					case *ast.Ident:
-						id = t
-					}
-					if id == nil {
-						break
-					}
-					if name := id.Name; o.RmTypes[name] {
-						// Type to be removed.
-						if o.Log != nil {
-							o.Log.Printf("method for type %s discarded", name)
 						}
-						continue next
 					}
 				}
-				if err := printNode(&buf, pkg.Fset, decl); err != nil {
+				if err := checkRemoved(t, pkg.Fset, pkg.TypesInfo, decl); err != nil {
+					return err
+				}
+				if err := buf.printDecl(pkg.Fset, f, decl); err != nil {
 					return err
 				}
 			}
 		}
 	}
+	return nil
+}
 
-	// Resolve imports and format the resulting code.
-	if o.Log != nil {
-		o.Log.Printf("Resolving imports\n")
+// prefixDecls prefixes the global declarations of the packages and checks
+// that the prefixed names do not collide.
+func prefixDecls(t *Transformation) error {
+	t.Logf("Prefixing types in %v\n", t.Pkgs)
+	prefixPkgs(t.Pkgs, t.prefix, t.Objs, t.Rename)
+	return checkCollisions(t.Pkgs, t.prefix, t.Objs)
+}
+
+// renameTests renames the test functions so that they are still run by go test
+// and discards TestMain.
+func renameTests(t *Transformation) error {
+	if !t.Tests {
+		return nil
 	}
-	code, err := imports.Process("", buf.Bytes(), nil)
-	if err != nil {
-		// Output without imports.
-		_, _ = io.Copy(out, &buf)
-		return err
+	for _, pkg := range t.Pkgs {
+		for _, f := range pkg.Syntax {
+			if !isTestFile(pkg, f) {
+				continue
+			}
+			for _, decl := range f.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Recv != nil {
+					continue
+				}
+				obj := pkg.TypesInfo.Defs[fn.Name]
+				if obj == nil {
+					continue
+				}
+				if obj.Name() == "TestMain" {
+					t.Logf("func TestMain discarded")
+					t.Remove(fn)
+					continue
+				}
+				if !t.Objs[obj] {
+					continue
+				}
+				if name, ok := testName(t.prefix(obj.Pkg()), obj.Name()); ok {
+					t.Rename(fn.Name, name)
+				}
+			}
+		}
 	}
-	_, err = io.Copy(out, bytes.NewReader(code))
+	return nil
+}
 
-	return err
+// unqualifyPkgs replaces the references to the bundled packages by their prefixed declarations,
+// which also merges the external test package with the package under test.
+func unqualifyPkgs(t *Transformation) error {
+	if len(t.Pkgs) < 2 {
+		return nil
+	}
+	local := map[*types.Package]bool{}
+	for _, pkg := range t.Pkgs {
+		local[pkg.Types] = true
+	}
+	for _, pkg := range t.Pkgs {
+		t.Defer(unqualify(pkg, local))
+	}
+	return nil
 }
@@ -3,7 +3,10 @@ package packagen
 import (
 	"bytes"
 	"flag"
+	"go/ast"
+	"go/types"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -40,6 +43,172 @@ func TestBundle(t *testing.T) {
 			Types:   map[string]string{"A": "A"},
 			RmTypes: map[string]bool{"A": true},
 		},
+		// Only keep the declarations reachable from the roots.
+		{
+			Pkg:    "./testdata/bundle",
+			NewPkg: "roots",
+			Prefix: "prefix",
+			Roots:  map[string]bool{"AS": true},
+		},
+		// Update constants of any form.
+		{
+			Pkg:    "./testdata/consts",
+			NewPkg: "consts",
+			Prefix: "prefix",
+			Const: map[string]string{
+				"BlockSize": "1 << 12",
+				"Ratio":     "0.75",
+				"Name":      `"xyz"`,
+				"B":         "10",
+				"Max":       "BlockSize * 4",
+			},
+		},
+		// Rename types to type expressions, including packages import paths,
+		// the renamed types being removed even if not listed in RmTypes.
+		{
+			Pkg:     "./testdata/typeexpr",
+			NewPkg:  "typeexpr",
+			Prefix:  "prefix",
+			Types:   map[string]string{"Item": "*go/token.File"},
+			RmTypes: map[string]bool{"Item": true},
+		},
+		{
+			Pkg:    "./testdata/typeexpr",
+			NewPkg: "typeexprmap",
+			Prefix: "prefix",
+			Types:  map[string]string{"Item": "map[string]func(a, b int) error"},
+		},
+		// Rename types to predeclared types, keeping the constants of the target type.
+		{
+			Pkg:    "./testdata/predeclared",
+			NewPkg: "predeclared",
+			Prefix: "p",
+			Types:  map[string]string{"Item": "int"},
+		},
+		// Emit //line directives.
+		{
+			Pkg:    "./testdata/bundle",
+			NewPkg: "lines",
+			Prefix: "prefix",
+			Lines:  true,
+		},
+		// Generics, any and min/max builtins.
+		{
+			Pkg:     "./testdata/modern",
+			NewPkg:  "modern",
+			Prefix:  "prefix",
+			Types:   map[string]string{"Item": "int64"},
+			RmTypes: map[string]bool{"Item": true, "Pair": true},
+		},
+		// Inline the internal dependencies.
+		{
+			Pkg:    "./testdata/inline",
+			NewPkg: "inline",
+			Prefix: "prefix",
+			Inline: []string{"github.com/pierrec/packagen/testdata/inline/internal/..."},
+		},
+		// Only the template types are renamed, not the inlined ones with the same name.
+		{
+			Pkg:    "./testdata/samename",
+			NewPkg: "samename",
+			Prefix: "prefix",
+			Types:  map[string]string{"Item": "Elem"},
+			Inline: []string{"github.com/pierrec/packagen/testdata/samename/internal/..."},
+		},
+		// Bundle packages referring to each other.
+		{
+			Pkg:    "./testdata/multi/...",
+			NewPkg: "multi",
+		},
+		// Instantiate the generic declarations.
+		{
+			Pkg:      "./testdata/generic",
+			NewPkg:   "instantiate",
+			Prefix:   "Int64",
+			TypeArgs: map[string]string{"K": "string", "V": "int64"},
+		},
+		// Multiple instances in the same bundle.
+		{
+			Pkg:    "./testdata/bundle",
+			NewPkg: "instances",
+			Instances: []BundleInstance{
+				{
+					Prefix: "one",
+					Types:  map[string]string{"S": "X"},
+				},
+				{
+					Prefix:  "two",
+					RmConst: map[string]bool{"V": true},
+				},
+			},
+		},
+		// Rewrite rules applied after renaming.
+		{
+			Pkg:     "./testdata/rewrite",
+			NewPkg:  "rewrite",
+			Prefix:  "Int",
+			Types:   map[string]string{"Item": "int64", "Key": "string"},
+			RmTypes: map[string]bool{"Item": true, "Key": true},
+			Rewrite: []string{"a.Less(b) -> a < b", "bytes.Equal(x, y) -> x == y"},
+		},
+		// The declarations used by the rewritten expressions are reachable.
+		{
+			Pkg:     "./testdata/rewrite",
+			NewPkg:  "rewriteroots",
+			Prefix:  "p",
+			Types:   map[string]string{"Item": "int"},
+			Roots:   map[string]bool{"Neg": true},
+			Rewrite: []string{"a.Less(b) -> a < b"},
+		},
+		// Removed functions, variables and methods.
+		{
+			Pkg:       "./testdata/remove",
+			NewPkg:    "remove",
+			RmFuncs:   map[string]bool{"re:^debug": true},
+			RmVars:    map[string]bool{"re:^debug": true},
+			RmMethods: map[string]bool{"T.Dump": true},
+		},
+		// Template with directives.
+		{
+			Pkg:    "./testdata/directives",
+			NewPkg: "directives",
+			Prefix: "Int64",
+			Types:  map[string]string{"Item": "int64"},
+			Const:  map[string]string{"Size": "32"},
+		},
+		// Template with directives and //line directives.
+		{
+			Pkg:    "./testdata/directives",
+			NewPkg: "directiveslines",
+			Prefix: "Int64",
+			Types:  map[string]string{"Item": "int64"},
+			Const:  map[string]string{"Size": "32"},
+			Lines:  true,
+		},
+		// Conditional sections.
+		{
+			Pkg:    "./testdata/cond",
+			NewPkg: "cond",
+			Prefix: "Int",
+			Types:  map[string]string{"Item": "int"},
+			Const:  map[string]string{"Size": "0"},
+		},
+		// Conditional sections with //line directives, after the doc comments.
+		{
+			Pkg:    "./testdata/cond",
+			NewPkg: "condlines",
+			Prefix: "Int",
+			Types:  map[string]string{"Item": "int"},
+			Const:  map[string]string{"Size": "0"},
+			Lines:  true,
+		},
+		// Custom transformation.
+		{
+			Pkg:          "./testdata/bundle",
+			NewPkg:       "transform",
+			Prefix:       "prefix",
+			Transformers: []Transformer{TransformerFunc(rmGoString)},
+		},
 	} {
 		t.Run(tc.Pkg, func(t *testing.T) {
 			c := qt.New(t)
@@ -61,3 +230,270 @@ func TestBundle(t *testing.T) {
 		})
 	}
 }
+
+// rmGoString removes the GoString methods and renames the String ones to Str.
+func rmGoString(t *Transformation) error {
+	for _, pkg := range t.Pkgs {
+		for _, f := range pkg.Syntax {
+			for _, decl := range f.Decls {
+				if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv != nil && fn.Name.Name == "GoString" {
+					t.Remove(fn)
+				}
+			}
+		}
+		for id, obj := range pkg.TypesInfo.Uses {
+			if fn, ok := obj.(*types.Func); ok && fn.Pkg() == pkg.Types && fn.Name() == "String" {
+				t.Rename(id, "Str")
+			}
+		}
+		for id, obj := range pkg.TypesInfo.Defs {
+			if fn, ok := obj.(*types.Func); ok && fn.Name() == "String" {
+				t.Rename(id, "Str")
+			}
+		}
+	}
+	return nil
+}
+
+func TestBundleConstErrors(t *testing.T) {
+	for _, tc := range []map[string]string{
+		{"Missing": "1"},
+		{"Name": "1"},
+		{"BlockSize": `"abc"`},
+		{"Small": "300"},
+		{"A": "1.5"},
+		{"B": "len(x)"},
+		{"BlockSize": "0.75"},
+	} {
+		c := qt.New(t)
+
+		buf := new(bytes.Buffer)
+		err := Bundle(buf, BundleOption{
+			Pkg:    "./testdata/consts",
+			NewPkg: "consts",
+			Const:  tc,
+		})
+		c.Assert(err, qt.Not(qt.IsNil), qt.Commentf("%v", tc))
+	}
+}
+
+func TestBundleUntypedConsts(t *testing.T) {
+	// Untyped constants accept values of the same kind representable by their default type.
+	for _, tc := range []map[string]string{
+		{"Ratio": "1"},
+		{"BlockSize": "4.0"},
+		{"BlockSize": "'a'"},
+	} {
+		c := qt.New(t)
+
+		err := Bundle(new(bytes.Buffer), BundleOption{
+			Pkg:    "./testdata/consts",
+			NewPkg: "consts",
+			Const:  tc,
+		})
+		c.Assert(err, qt.IsNil, qt.Commentf("%v", tc))
+	}
+}
+
+func TestBundleRewrite(t *testing.T) {
+	c := qt.New(t)
+
+	o := BundleOption{
+		Pkg:     "./testdata/rewrite",
+		NewPkg:  "rewrite",
+		Rewrite: []string{"a.Less(b)"},
+	}
+	err := Bundle(new(bytes.Buffer), o)
+	c.Assert(err, qt.Not(qt.IsNil))
+
+	// The rewritten expressions are restored.
+	o.Rewrite = []string{"a.Less(b) -> a < b"}
+	err = Bundle(new(bytes.Buffer), o)
+	c.Assert(err, qt.IsNil)
+	o.Rewrite = nil
+	buf := new(bytes.Buffer)
+	err = Bundle(buf, o)
+	c.Assert(err, qt.IsNil)
+	c.Assert(buf.String(), qt.Contains, "it.Less(m)")
+	c.Assert(buf.String(), qt.Contains, "items[i].Less(items[i-1])")
+
+	// The declarations used by the rewritten expressions cannot be removed.
+	o.Rewrite = []string{"a.Less(b) -> a < b"}
+	o.RmFuncs = map[string]bool{"zero": true}
+	err = Bundle(new(bytes.Buffer), o)
+	c.Assert(err, qt.ErrorMatches, ".*zero.*")
+}
+
+func TestBundleRemoveErrors(t *testing.T) {
+	for _, tc := range []BundleOption{
+		{RmFuncs: map[string]bool{"helper": true}},
+		{RmVars: map[string]bool{"count": true}},
+		{RmFuncs: map[string]bool{"re:^debug": true}},
+		{RmFuncs: map[string]bool{"re:(": true}},
+	} {
+		c := qt.New(t)
+
+		tc.Pkg = "./testdata/remove"
+		tc.NewPkg = "remove"
+		err := Bundle(new(bytes.Buffer), tc)
+		c.Assert(err, qt.Not(qt.IsNil), qt.Commentf("%v", tc))
+	}
+}
+
+func TestBundleInstantiateErrors(t *testing.T) {
+	for _, tc := range []struct {
+		pkg  string
+		args map[string]string
+		err  string
+	}{
+		{"./testdata/generic", map[string]string{"K": "string"}, ".*missing type argument for V"},
+		{"./testdata/generic", map[string]string{"K": "string", "V": "int64", "T": "int"}, `type parameters not found: \[T\]`},
+		{"./testdata/generic", map[string]string{"K": "string", "V": "string"}, ".*string does not satisfy .*Number.*"},
+		{"./testdata/genericconflict", map[string]string{"T": "int"}, ".*List instantiated with string instead of int"},
+	} {
+		c := qt.New(t)
+
+		err := Bundle(new(bytes.Buffer), BundleOption{
+			Pkg:      tc.pkg,
+			NewPkg:   "instantiate",
+			TypeArgs: tc.args,
+		})
+		c.Assert(err, qt.ErrorMatches, tc.err, qt.Commentf("%v", tc.args))
+	}
+}
+
+func TestBundleTypesErrors(t *testing.T) {
+	c := qt.New(t)
+
+	// Misspelled options of the directives are renamed types.
+	err := Bundle(new(bytes.Buffer), BundleOption{
+		Pkg:    "./testdata/bundle",
+		NewPkg: "bundle",
+		Types:  map[string]string{"A": "string", "prefx": "Int64", "rmtypes": "A"},
+	})
+	c.Assert(err, qt.ErrorMatches, `types not found: \[prefx rmtypes\]`)
+}
+
+func TestBundleDirectives(t *testing.T) {
+	c := qt.New(t)
+
+	// Only the constants with the const directive can be updated.
+	err := Bundle(new(bytes.Buffer), BundleOption{
+		Pkg:    "./testdata/directives",
+		NewPkg: "directives",
+		Const:  map[string]string{"Version": "2"},
+	})
+	c.Assert(err, qt.Not(qt.IsNil))
+}
+
+func TestBundleCheck(t *testing.T) {
+	c := qt.New(t)
+
+	o := BundleOption{
+		Pkg:       "./testdata/check/tmpl",
+		NewPkg:    "dst",
+		Prefix:    "prefix",
+		RmTypes:   map[string]bool{"Item": true},
+		CheckFile: filepath.Join("testdata", "check", "dst", "gen.go"),
+	}
+
+	o.Types = map[string]string{"Item": "Num"}
+	err := Bundle(new(bytes.Buffer), o)
+	c.Assert(err, qt.IsNil)
+
+	o.Types = map[string]string{"Item": "Pair"}
+	err = Bundle(new(bytes.Buffer), o)
+	errs, ok := err.(CheckErrors)
+	c.Assert(ok, qt.Equals, true, qt.Commentf("%v", err))
+	c.Assert(errs, qt.HasLen, 1)
+	c.Assert(filepath.Base(errs[0].Pos.Filename), qt.Equals, "gen.go")
+	c.Assert(filepath.Base(errs[0].Template.Filename), qt.Equals, "tmpl.go")
+	c.Assert(errs[0].Template.Line, qt.Equals, 6)
+	line := errs[0].Pos.Line
+
+	// Errors are positioned in the code written with its header.
+	o.Header = "// Header\n// written before the code.\n\n"
+	err = Bundle(new(bytes.Buffer), o)
+	errs, ok = err.(CheckErrors)
+	c.Assert(ok, qt.Equals, true, qt.Commentf("%v", err))
+	c.Assert(errs, qt.HasLen, 1)
+	c.Assert(errs[0].Pos.Line, qt.Equals, line+3)
+	c.Assert(errs[0].Template.Line, qt.Equals, 6)
+	o.Header = ""
+
+	// Errors point to the template with //line directives.
+	o.Lines = true
+	err = Bundle(new(bytes.Buffer), o)
+	errs, ok = err.(CheckErrors)
+	c.Assert(ok, qt.Equals, true, qt.Commentf("%v", err))
+	c.Assert(errs, qt.HasLen, 1)
+	c.Assert(filepath.Base(errs[0].Pos.Filename), qt.Equals, "tmpl.go")
+	c.Assert(errs[0].Pos.Line, qt.Equals, 6)
+	o.Lines = false
+
+	// The new content of the other generated files is checked along the code.
+	other := filepath.Join("testdata", "check", "dst", "other.go")
+	o.Types = map[string]string{"Item": "Num"}
+	o.Overlay = map[string][]byte{other: []byte("package dst\n\nvar _ = prefixMax(1, 2)\n")}
+	err = Bundle(new(bytes.Buffer), o)
+	c.Assert(err, qt.IsNil)
+	o.Overlay = map[string][]byte{other: []byte("package dst\n\nfunc prefixMax() {}\n")}
+	err = Bundle(new(bytes.Buffer), o)
+	c.Assert(err, qt.ErrorMatches, `(?s).*prefixMax redeclared.*`)
+}
+
+func TestBundleTests(t *testing.T) {
+	for _, tc := range []BundleOption{
+		{
+			Pkg:    "./testdata/bundle",
+			NewPkg: "tests",
+			Prefix: "prefix",
+			Types:  map[string]string{"S": "X"},
+		},
+	} {
+		t.Run(tc.Pkg, func(t *testing.T) {
+			c := qt.New(t)
+
+			buf := new(bytes.Buffer)
+			err := BundleTests(buf, tc)
+			c.Assert(err, qt.IsNil)
+
+			fname := filepath.Join("testdata", "bundle_"+tc.NewPkg+"_test.golden")
+			if *update {
+				t.Log("update golden file")
+				if err := ioutil.WriteFile(fname, buf.Bytes(), 0644); err != nil {
+					t.Fatalf("failed to update golden file: %s", err)
+				}
+			}
+			result, err := ioutil.ReadFile(fname)
+			c.Assert(err, qt.IsNil)
+			c.Assert(buf.String(), qt.Equals, string(result))
+
+			// The tests compile with the bundle.
+			bundle := new(bytes.Buffer)
+			c.Assert(Bundle(bundle, tc), qt.IsNil)
+			dir, err := ioutil.TempDir("testdata", tc.NewPkg)
+			c.Assert(err, qt.IsNil)
+			defer os.RemoveAll(dir)
+			overlay := map[string][]byte{filepath.Join(dir, "gen.go"): bundle.Bytes()}
+			err = checkCode(filepath.Join(dir, "gen_test.go"), buf.Bytes(), true, nil, overlay)
+			c.Assert(err, qt.IsNil)
+		})
+	}
+}
+
+func TestBundleOutDir(t *testing.T) {
+	c := qt.New(t)
+
+	buf := new(bytes.Buffer)
+	err := Bundle(buf, BundleOption{
+		Pkg:    "./testdata/bundle",
+		NewPkg: "outdir",
+		Prefix: "prefix",
+		Lines:  true,
+		OutDir: filepath.Join("testdata", "check", "dst"),
+	})
+	c.Assert(err, qt.IsNil)
+	// The //line directives are relative to the output directory.
+	c.Assert(buf.String(), qt.Contains, "//line ../../bundle/bundle.go:3\n")
+}
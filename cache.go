@@ -1,9 +1,13 @@
 package packagen
 
 import (
+	"fmt"
+	"go/build"
 	"go/parser"
 	"go/token"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/pierrec/packagen/internal/par"
@@ -18,10 +22,14 @@ func localPkgName() (string, error) {
 		name string
 		err  error
 	}
-	if res, ok := localPkgNameCache.Get("").(*result); ok {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	if res, ok := localPkgNameCache.Get(dir).(*result); ok {
 		return res.name, res.err
 	}
-	res := localPkgNameCache.Do("", func() interface{} {
+	res := localPkgNameCache.Do(dir, func() interface{} {
 		if gofile := os.Getenv("OSFILE"); gofile != "" {
 			// Fast path.
 			f, err := parser.ParseFile(token.NewFileSet(), gofile, nil, parser.PackageClauseOnly)
@@ -44,22 +52,166 @@ func localPkgName() (string, error) {
 var pkgCache par.Cache
 
 // loadPkg loads the packages matching the patterns, as per golang.org/x/tools/go/packages.Load().
-// The result is cached and returned upon subsequent calls.
+// The result is cached per working directory and returned upon subsequent calls.
 func loadPkg(patterns ...string) ([]*packages.Package, error) {
-	type result struct {
-		pkgs []*packages.Package
-		err  error
+	return load(false, patterns)
+}
+
+// loadTestPkg is similar to loadPkg but also loads the test packages.
+func loadTestPkg(patterns ...string) ([]*packages.Package, error) {
+	return load(true, patterns)
+}
+
+// loadResult is a pkgCache entry.
+type loadResult struct {
+	pkgs []*packages.Package
+	err  error
+}
+
+func load(tests bool, patterns []string) ([]*packages.Package, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%s:%t:%s", dir, tests, strings.Join(patterns, " "))
+	if usedKeys != nil {
+		usedKeys[key] = true
 	}
-	key := strings.Join(patterns, " ")
 	res := pkgCache.Do(key, func() interface{} {
 		// Only declare the minimum load modes.
-		mode := packages.NeedName |
+		mode := packages.NeedName | packages.NeedFiles |
 			packages.NeedImports | packages.NeedDeps |
 			packages.NeedTypes | packages.NeedTypesSizes |
 			packages.NeedSyntax | packages.NeedTypesInfo
 
-		pkgs, err := packages.Load(&packages.Config{Mode: mode}, patterns...)
-		return &result{pkgs, err}
-	}).(*result)
+		pkgs, err := packages.Load(&packages.Config{Mode: mode, Tests: tests}, patterns...)
+		return &loadResult{pkgs, err}
+	}).(*loadResult)
 	return res.pkgs, res.err
 }
+
+// cachedPkgs calls f for every cached package and its dependencies.
+// The cache entries without packages, e.g. failed loads, are passed a nil package.
+func cachedPkgs(f func(key interface{}, pkg *packages.Package)) {
+	// par.Cache does not provide an iterator.
+	pkgCache.DeleteIf(func(key interface{}) bool {
+		res, ok := pkgCache.Get(key).(*loadResult)
+		if !ok || len(res.pkgs) == 0 {
+			f(key, nil)
+			return false
+		}
+		packages.Visit(res.pkgs, nil, func(pkg *packages.Package) {
+			f(key, pkg)
+		})
+		return false
+	})
+}
+
+// CachedDirs returns the source directories of the cached packages and their dependencies,
+// except the ones from the standard library and the module cache that are not expected to change.
+func CachedDirs() []string {
+	return pkgDirs(nil)
+}
+
+// usedKeys, if not nil, records the keys of the cache entries loaded by UsedDirs.
+var usedKeys map[interface{}]bool
+
+// UsedDirs runs f and returns the source directories of the packages it loads and of their
+// dependencies, except the ones ignored by CachedDirs.
+func UsedDirs(f func() error) ([]string, error) {
+	keys := map[interface{}]bool{}
+	defer func(prev map[interface{}]bool) {
+		// The enclosing calls also use the packages.
+		for key := range keys {
+			if prev != nil {
+				prev[key] = true
+			}
+		}
+		usedKeys = prev
+	}(usedKeys)
+	usedKeys = keys
+	err := f()
+	return pkgDirs(keys), err
+}
+
+// pkgDirs returns the source directories of the packages of the cache entries with the given
+// keys, all of them if nil, and their dependencies.
+func pkgDirs(keys map[interface{}]bool) []string {
+	ignored := []string{
+		filepath.Join(build.Default.GOROOT, "src") + string(filepath.Separator),
+		filepath.Join(build.Default.GOPATH, "pkg", "mod") + string(filepath.Separator),
+	}
+	seen := map[string]bool{}
+	cachedPkgs(func(key interface{}, pkg *packages.Package) {
+		if pkg == nil || keys != nil && !keys[key] {
+			return
+		}
+	files:
+		for _, fname := range pkg.GoFiles {
+			dir := filepath.Dir(fname)
+			if seen[dir] {
+				continue
+			}
+			for _, prefix := range ignored {
+				if strings.HasPrefix(fname, prefix) {
+					continue files
+				}
+			}
+			seen[dir] = true
+		}
+	})
+	dirs := make([]string, 0, len(seen))
+	for dir := range seen {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// Invalidate removes from the cache the packages depending on the source files of
+// the given directories, as well as the failed loads, so that they are reloaded when next used.
+func Invalidate(dirs ...string) {
+	changed := map[string]bool{}
+	for _, dir := range dirs {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		changed[dir] = true
+		localPkgNameCache.Delete(dir)
+	}
+	stale := map[interface{}]bool{}
+	cachedPkgs(func(key interface{}, pkg *packages.Package) {
+		if pkg == nil {
+			stale[key] = true
+			return
+		}
+		for _, fname := range pkg.GoFiles {
+			if changed[filepath.Dir(fname)] {
+				stale[key] = true
+				return
+			}
+		}
+	})
+	pkgCache.DeleteIf(func(key interface{}) bool { return stale[key] })
+}
+
+var pkgNameCache par.Cache
+
+// importName returns the name of the package with the given import path.
+func importName(path string) (string, error) {
+	type result struct {
+		name string
+		err  error
+	}
+	res := pkgNameCache.Do(path, func() interface{} {
+		pkgs, err := packages.Load(&packages.Config{Mode: packages.NeedName}, path)
+		if err != nil {
+			return &result{err: err}
+		}
+		if len(pkgs) != 1 || pkgs[0].Name == "" {
+			return &result{err: fmt.Errorf("package %s not found", path)}
+		}
+		return &result{name: pkgs[0].Name}
+	}).(*result)
+	return res.name, res.err
+}
@@ -0,0 +1,157 @@
+package packagen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// CheckError is a type error found in the generated code.
+type CheckError struct {
+	Pos      token.Position // Position of the error in the generated code
+	Template token.Position // Position in the template that caused the error, if known
+	Msg      string
+}
+
+func (e CheckError) Error() string {
+	if !e.Template.IsValid() {
+		return fmt.Sprintf("%v: %s", e.Pos, e.Msg)
+	}
+	return fmt.Sprintf("%v: %s (template %v)", e.Pos, e.Msg, e.Template)
+}
+
+// CheckErrors lists the type errors found in the generated code.
+type CheckErrors []CheckError
+
+func (e CheckErrors) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+// output holds the generated declarations and their position in the template.
+type output struct {
+	bytes.Buffer
+	origins []token.Position
+	lines   bool   // Emit //line directives
+	dir     string // Output directory
+}
+
+// printDecl writes the declaration of the file f to the output.
+func (out *output) printDecl(fset *token.FileSet, f *ast.File, decl ast.Decl) error {
+	out.origins = append(out.origins, fset.Position(decl.Pos()))
+	if out.lines {
+		return printLineDecl(&out.Buffer, fset, f, decl, out.dir)
+	}
+	restore, err := printDoc(&out.Buffer, decl)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	return printNode(&out.Buffer, fset, decl)
+}
+
+// checkCode type checks the code as if it was the file fname in its package, the files of
+// the overlay, keyed by path, replacing the ones on disk.
+// Errors are mapped back to the template declarations using origins, which
+// must be in the same order as the declarations in the code.
+func checkCode(fname string, code []byte, tests bool, origins []token.Position, overlay map[string][]byte) error {
+	fname, err := filepath.Abs(fname)
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{}
+	for name, data := range overlay {
+		name, err := filepath.Abs(name)
+		if err != nil {
+			return err
+		}
+		files[name] = data
+	}
+	files[fname] = code
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles |
+			packages.NeedImports | packages.NeedDeps |
+			packages.NeedTypes | packages.NeedTypesSizes,
+		Dir:     filepath.Dir(fname),
+		Tests:   tests,
+		Overlay: files,
+	}
+	pkgs, err := packages.Load(cfg, ".")
+	if err != nil {
+		return err
+	}
+
+	// Line ranges of the declarations in the code.
+	type lines struct{ start, end int }
+	var decls []lines
+	fset := token.NewFileSet()
+	if f, err := parser.ParseFile(fset, fname, code, 0); err == nil {
+		for _, decl := range f.Decls {
+			if d, ok := decl.(*ast.GenDecl); ok && d.Tok == token.IMPORT {
+				continue
+			}
+			decls = append(decls, lines{fset.Position(decl.Pos()).Line, fset.Position(decl.End()).Line})
+		}
+	}
+	if len(decls) != len(origins) {
+		// Cannot map the declarations.
+		decls = nil
+	}
+
+	var errs CheckErrors
+	seen := map[string]bool{}
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		for _, e := range pkg.Errors {
+			if seen[e.Error()] {
+				// Test variants report the same errors.
+				continue
+			}
+			seen[e.Error()] = true
+			cerr := CheckError{Pos: parsePosition(e.Pos), Msg: e.Msg}
+			if cerr.Pos.Filename == fname {
+				for i, d := range decls {
+					if line := cerr.Pos.Line; d.start <= line && line <= d.end {
+						cerr.Template = origins[i]
+						cerr.Template.Line += line - d.start
+						cerr.Template.Column = 0
+						break
+					}
+				}
+			}
+			errs = append(errs, cerr)
+		}
+	})
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// parsePosition parses a position in the file:line:column format.
+func parsePosition(s string) token.Position {
+	var pos token.Position
+	for i := 0; i < 2; i++ {
+		j := strings.LastIndexByte(s, ':')
+		if j < 0 {
+			break
+		}
+		n, err := strconv.Atoi(s[j+1:])
+		if err != nil {
+			break
+		}
+		pos.Line, pos.Column = n, pos.Line
+		s = s[:j]
+	}
+	pos.Filename = s
+	return pos
+}
@@ -3,78 +3,287 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
 
 	"github.com/pierrec/cmdflag"
 	"github.com/pierrec/packagen"
 )
 
+// instanceFlags holds the flags defining a bundle instance.
+type instanceFlags struct {
+	prefix  string
+	mvtype  string
+	rmtype  string
+	upconst string
+	rmconst string
+	rmfunc  string
+	rmvar   string
+	rmmeth  string
+	rm      string
+	roots   string
+	targs   string
+	rewrite stringList
+	outfile string
+}
+
+func (f *instanceFlags) register(set *flag.FlagSet) {
+	set.StringVar(&f.prefix, "prefix", f.prefix,
+		"prefix used to rename declarations (default=packageName_)")
+	set.StringVar(&f.mvtype, "mvtype", f.mvtype,
+		fmt.Sprintf("list of named types to be renamed: old%cnew[%c ...] (new can be a type expression, e.g. *example.com/pkg.Type)", typeSep, listSep))
+	set.StringVar(&f.rmtype, "rmtype", f.rmtype,
+		fmt.Sprintf("list of named types to be removed: typename[%c ...]", listSep))
+	set.StringVar(&f.upconst, "const", f.upconst,
+		fmt.Sprintf("list of constants to be updated: constname%cexpression[%c ...]", typeSep, listSep))
+	set.StringVar(&f.rmconst, "rmconst", f.rmconst,
+		fmt.Sprintf("list of constants to be discarded: constname[%c ...]", listSep))
+	set.StringVar(&f.rmfunc, "rmfunc", f.rmfunc,
+		fmt.Sprintf("list of functions to be removed: funcname[%c ...] (re:regexp selects the matching ones, e.g. re:^debug)", listSep))
+	set.StringVar(&f.rmvar, "rmvar", f.rmvar,
+		fmt.Sprintf("list of variables to be removed: varname[%c ...] (re:regexp selects the matching ones)", listSep))
+	set.StringVar(&f.rmmeth, "rmmethod", f.rmmeth,
+		fmt.Sprintf("list of methods to be removed: Type.Method[%c ...] (re:regexp selects the matching ones)", listSep))
+	set.StringVar(&f.rm, "rm", f.rm,
+		fmt.Sprintf("list of functions, variables and methods (as Type.Method) to be removed: name[%c ...] (re:regexp selects the matching ones)", listSep))
+	set.StringVar(&f.roots, "roots", f.roots,
+		fmt.Sprintf("list of declarations to keep with the ones they use, discarding the others: name[%c ...]", listSep))
+	set.StringVar(&f.targs, "args", f.targs,
+		fmt.Sprintf("list of type arguments instantiating the generic declarations: typeparam%ctype[%c ...]", typeSep, listSep))
+	set.Var(&f.rewrite, "rewrite",
+		"rewrite rule applied after renaming: 'pattern -> replacement' (e.g. 'a.Less(b) -> a < b', repeatable)")
+	set.StringVar(&f.outfile, "o", f.outfile, "write output to `file` (default=standard output)")
+}
+
+func (f *instanceFlags) instance() (inst packagen.BundleInstance, err error) {
+	inst.Prefix = f.prefix
+	inst.Types, err = toMapString(f.mvtype)
+	if err != nil {
+		return
+	}
+	inst.Const, err = toMapString(f.upconst)
+	if err != nil {
+		return
+	}
+	inst.TypeArgs, err = toMapString(f.targs)
+	if err != nil {
+		return
+	}
+	inst.RmTypes = toMapBool(f.rmtype)
+	inst.RmConst = toMapBool(f.rmconst)
+	inst.RmFuncs = toMapBool(f.rmfunc)
+	inst.RmVars = toMapBool(f.rmvar)
+	inst.RmMethods = toMapBool(f.rmmeth)
+	for name := range toMapBool(f.rm) {
+		inst.RmFuncs[name] = true
+		inst.RmVars[name] = true
+		inst.RmMethods[name] = true
+	}
+	inst.Roots = toMapBool(f.roots)
+	inst.Rewrite = f.rewrite
+	return
+}
+
+// parseInstance parses the flags of an instance, using the flags defined in base as default values.
+func parseInstance(base instanceFlags, args string) (packagen.BundleInstance, string, error) {
+	set := flag.NewFlagSet("instance", flag.ContinueOnError)
+	// The rewrite rules of the instance replace the default ones.
+	rewrite := base.rewrite
+	base.rewrite = nil
+	base.register(set)
+	list, err := splitArgs(args)
+	if err != nil {
+		return packagen.BundleInstance{}, "", err
+	}
+	if err := set.Parse(list); err != nil {
+		return packagen.BundleInstance{}, "", err
+	}
+	if set.NArg() > 0 {
+		return packagen.BundleInstance{}, "", fmt.Errorf("invalid instance arguments: %v", set.Args())
+	}
+	if base.rewrite == nil {
+		base.rewrite = rewrite
+	}
+	inst, err := base.instance()
+	return inst, base.outfile, err
+}
+
 func init() {
 	cli.MustAdd(cmdflag.Application{
 		Name:  "bundle",
 		Descr: "bundle all files in a package",
 		Args:  "package to be processed",
 		Err:   flag.ExitOnError,
-		Init: func(set *flag.FlagSet) cmdflag.Handler {
-			o := packagen.BundleOption{Log: newLogger()}
-			var nogen bool
-			set.BoolVar(&nogen, "nogen", false, "do not add the generate directive")
-
-			set.StringVar(&o.NewPkg, "newpkg", "",
-				"new package name (default=current working dir package)")
-			set.StringVar(&o.Prefix, "prefix", "",
-				"prefix used to rename declarations (default=packageName_)")
-
-			var mvtype string
-			set.StringVar(&mvtype, "mvtype", "",
-				fmt.Sprintf("list of named types to be renamed: old%cnew[%c ...]", typeSep, listSep))
-
-			var rmtype string
-			set.StringVar(&rmtype, "rmtype", "",
-				fmt.Sprintf("list of named types to be removed: typename[%c ...]", listSep))
-
-			var upconst string
-			set.StringVar(&upconst, "const", "",
-				fmt.Sprintf("list of integer constants to be updated: constname%cinteger[%c ...]", typeSep, listSep))
-
-			var rmconst string
-			set.StringVar(&rmconst, "rmconst", "",
-				fmt.Sprintf("list of constants to be discarded: constname[%c ...]", typeSep))
-
-			var outfile string
-			set.StringVar(&outfile, "o", "", "write output to `file` (default=standard output)")
-
-			return func(args ...string) (_ int, err error) {
-				switch len(args) {
-				case 1:
-				case 0:
-					err = errMissingPkg
-					return
-				default:
-					err = errTooManyPkg
-					return
-				}
-				o.Types, err = toMapString(mvtype)
-				if err != nil {
-					return
-				}
-				o.Const, err = toMapInt(upconst)
-				if err != nil {
-					return
-				}
-				o.Pkg = args[0]
-				o.RmTypes = toMapBool(rmtype)
-				o.RmConst = toMapBool(rmconst)
+		Init:  bundleInit,
+	})
+	cli.MustAdd(cmdflag.Application{
+		Name:  "instantiate",
+		Descr: "bundle a package with its generic declarations instantiated with the -args type arguments",
+		Args:  "package to be processed",
+		Err:   flag.ExitOnError,
+		Init:  bundleInit,
+	})
+}
+
+// bundleInit defines the flags of the bundle command and returns its handler.
+func bundleInit(set *flag.FlagSet) cmdflag.Handler {
+	o := packagen.BundleOption{Log: newLogger()}
+	var nogen bool
+	set.BoolVar(&nogen, "nogen", false, "do not add the generate directive")
+	var tests, check bool
+	set.BoolVar(&tests, "tests", false,
+		"also bundle the package tests into the output files suffixed with _test (TestMain is discarded)")
+	set.BoolVar(&check, "check", false,
+		"type check the output files with their package before writing them")
 
-				out, err := initOutput(outfile, true, nogen)
-				if err != nil {
-					return
+	set.BoolVar(&o.Lines, "lines", false,
+		"emit //line directives pointing to the package declarations")
+	set.StringVar(&o.NewPkg, "newpkg", "",
+		"new package name (default=current working dir package)")
+	var inline string
+	set.StringVar(&inline, "inline", "",
+		fmt.Sprintf("list of import path patterns of the dependencies to be bundled too: pattern[%c ...] (e.g. example.com/mod/internal/...)", listSep))
+
+	plugins := pluginFlag(set)
+
+	var flags instanceFlags
+	flags.register(set)
+
+	var instances stringList
+	set.Var(&instances, "instance",
+		"flags of an instance (-prefix, -mvtype, -rmtype, -const, -rmconst, -rmfunc, -rmvar, -rmmethod, -rm, -roots, -args, -rewrite, -o), defaulting to the ones above (repeatable)")
+
+	return dryRun(set, func(args ...string) (_ int, err error) {
+		switch len(args) {
+		case 1:
+		case 0:
+			err = errMissingPkg
+			return
+		default:
+			err = errTooManyPkg
+			return
+		}
+		o.Pkg = args[0]
+		o.Inline = toList(inline)
+		o.Transformers = plugins()
+
+		// Group the instances by output file, in order.
+		var outfiles []string
+		groups := map[string][]packagen.BundleInstance{}
+		if len(instances) == 0 {
+			inst, err := flags.instance()
+			if err != nil {
+				return 0, err
+			}
+			outfiles = append(outfiles, flags.outfile)
+			groups[flags.outfile] = append(groups[flags.outfile], inst)
+		}
+		for _, args := range instances {
+			inst, outfile, err := parseInstance(flags, args)
+			if err != nil {
+				return 0, err
+			}
+			if _, ok := groups[outfile]; !ok {
+				outfiles = append(outfiles, outfile)
+			}
+			groups[outfile] = append(groups[outfile], inst)
+		}
+
+		write := func(check bool, overlay map[string][]byte) error {
+			for i, outfile := range outfiles {
+				o.Instances = groups[outfile]
+				// Only the first output gets the generate directive.
+				cfg := bundleConfig{
+					args:    cmdArgs,
+					nogen:   nogen || i > 0,
+					tests:   tests,
+					check:   check,
+					overlay: overlay,
 				}
-				err = packagen.Bundle(out, o)
-				if err != nil {
-					return
+				if err := bundleFile(outfile, cfg, o); err != nil {
+					return err
 				}
-				return len(args), out.Close()
 			}
-		},
+			return nil
+		}
+		var overlay map[string][]byte
+		if check {
+			// Errors are reported when writing the files.
+			overlay = outputs(func() { _ = write(false, nil) })
+		}
+		if err = write(check, overlay); err != nil {
+			return
+		}
+		return len(args), nil
 	})
 }
+
+// outputs returns the content of the files written by run, keyed by absolute path,
+// without writing them, so that the outputs of a run are type checked along each other.
+func outputs(run func()) map[string][]byte {
+	files := map[string][]byte{}
+	defer func(prev map[string][]byte) { memFiles = prev }(memFiles)
+	memFiles = files
+	run()
+	return files
+}
+
+// bundleConfig defines how bundle files are written.
+type bundleConfig struct {
+	args  []string // Command arguments recorded in the header
+	nogen bool     // Do not add the generate directive
+	tests bool     // Also bundle the package tests into the output file suffixed with _test
+	check bool     // Type check the output files before writing them
+	// New content of the other output files of the run, keyed by absolute path,
+	// type checked along the output files.
+	overlay map[string][]byte
+}
+
+// bundleFile bundles the package into outfile.
+func bundleFile(outfile string, cfg bundleConfig, o packagen.BundleOption) error {
+	if outfile != "" {
+		o.OutDir = filepath.Dir(outfile)
+	}
+	if cfg.check {
+		if outfile == "" {
+			return errMissingOutput
+		}
+		o.CheckFile = outfile
+		o.Overlay = cfg.overlay
+	}
+	if err := writeFile(outfile, cfg.args, cfg.nogen, o, packagen.Bundle); err != nil {
+		return err
+	}
+	if !cfg.tests {
+		return nil
+	}
+	if outfile == "" {
+		return errMissingOutput
+	}
+	testfile := strings.TrimSuffix(outfile, ".go") + "_test.go"
+	if cfg.check {
+		o.CheckFile = testfile
+	}
+	return writeFile(testfile, cfg.args, true, o, packagen.BundleTests)
+}
+
+func writeFile(outfile string, args []string, nogen bool, o packagen.BundleOption,
+	bundle func(io.Writer, packagen.BundleOption) error) error {
+	// The header is written by bundle so that it is type checked with the code.
+	if args != nil {
+		o.Header = header(args, nogen)
+	}
+	out, err := initOutput(outfile, nil, nogen)
+	if err != nil {
+		return err
+	}
+	if err := bundle(out, o); err != nil {
+		if f, ok := out.(*safeFile); ok {
+			// Leave the existing file untouched.
+			_ = f.Cleanup()
+		}
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestParseInstance(t *testing.T) {
+	c := qt.New(t)
+
+	base := instanceFlags{
+		prefix:  "Int",
+		rewrite: stringList{"a.Less(b) -> a < b"},
+	}
+	// The default rewrite rules apply to the instances without their own.
+	inst, outfile, err := parseInstance(base, "-o int.go")
+	c.Assert(err, qt.IsNil)
+	c.Assert(outfile, qt.Equals, "int.go")
+	c.Assert(inst.Prefix, qt.Equals, "Int")
+	c.Assert(inst.Rewrite, qt.DeepEquals, []string{"a.Less(b) -> a < b"})
+
+	inst, _, err = parseInstance(base,
+		`-prefix Bytes -rewrite 'a.Less(b) -> bytes.Compare(a, b) < 0' -rewrite "bytes.Equal(x, y) -> x == y"`)
+	c.Assert(err, qt.IsNil)
+	c.Assert(inst.Prefix, qt.Equals, "Bytes")
+	c.Assert(inst.Rewrite, qt.DeepEquals, []string{"a.Less(b) -> bytes.Compare(a, b) < 0", "bytes.Equal(x, y) -> x == y"})
+	c.Assert(base.rewrite, qt.DeepEquals, stringList{"a.Less(b) -> a < b"})
+
+	_, _, err = parseInstance(base, "-o int.go extra")
+	c.Assert(err, qt.ErrorMatches, `invalid instance arguments: \[extra\]`)
+}
+
+func TestBundleCheckOutputs(t *testing.T) {
+	c := qt.New(t)
+
+	dir := tempGen(c)
+	wd, err := os.Getwd()
+	c.Assert(err, qt.IsNil)
+	c.Assert(os.Chdir(dir), qt.IsNil)
+	defer os.Chdir(wd)
+	defer func(args []string) { cmdArgs = args }(cmdArgs)
+
+	// The outputs are checked along the new content of the other ones: IntList moves
+	// from list_int.go to list.go.
+	cmdArgs = []string{"bundle", "-check", "-newpkg", "gen", "-mvtype", "Item=int",
+		"-instance", "-prefix Int -o list.go",
+		"-instance", "-prefix Int64 -mvtype Item=int64 -o list_int.go",
+		"./tmpl"}
+	err = cli.Parse(cmdArgs...)
+	c.Assert(err, qt.IsNil)
+	data, err := os.ReadFile("list.go")
+	c.Assert(err, qt.IsNil)
+	c.Assert(strings.Contains(string(data), "type IntList []int\n"), qt.Equals, true)
+	data, err = os.ReadFile("list_int.go")
+	c.Assert(err, qt.IsNil)
+	c.Assert(strings.Contains(string(data), "type Int64List []int64\n"), qt.Equals, true)
+}
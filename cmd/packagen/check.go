@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pierrec/cmdflag"
+	"github.com/pierrec/packagen/internal/diff"
+)
+
+func init() {
+	cli.MustAdd(cmdflag.Application{
+		Name:  "check",
+		Descr: "check that the generated files are up to date",
+		Args:  "list of directories, ending with /... to include their subdirectories (default=.)",
+		Err:   flag.ExitOnError,
+		Init: func(set *flag.FlagSet) cmdflag.Handler {
+			return func(args ...string) (int, error) {
+				patterns := args
+				if len(patterns) == 0 {
+					patterns = []string{"."}
+				}
+				dirs, err := matchDirs(patterns)
+				if err != nil {
+					return 0, err
+				}
+				var stale int
+				for _, dir := range dirs {
+					n, err := checkDir(dir)
+					if err != nil {
+						return 0, err
+					}
+					stale += n
+				}
+				if stale > 0 {
+					return 0, fmt.Errorf("%d generated file(s) out of date", stale)
+				}
+				return len(args), nil
+			}
+		},
+	})
+}
+
+// genCommand is a command recorded in the header of generated files.
+type genCommand struct {
+	name  string
+	args  []string
+	files []string // Files generated by the command
+}
+
+// generatedFiles returns the commands recorded in the generated files of dir.
+func generatedFiles(dir string) ([]*genCommand, error) {
+	fnames, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	var cmds []*genCommand
+	index := map[string]*genCommand{}
+	for _, fname := range fnames {
+		header, err := readHeader(fname)
+		if err != nil {
+			return nil, err
+		}
+		name, args, ok := parseHeader(header)
+		if !ok {
+			continue
+		}
+		key := name + " " + quoteArgs(args)
+		cmd, ok := index[key]
+		if !ok {
+			cmd = &genCommand{name: name, args: args}
+			index[key] = cmd
+			cmds = append(cmds, cmd)
+		}
+		cmd.files = append(cmd.files, fname)
+	}
+	return cmds, nil
+}
+
+// readHeader returns the first bytes of the file.
+func readHeader(fname string) ([]byte, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, 1024)
+	n, err := io.ReadFull(f, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return buf[:n], err
+}
+
+// checkDir reruns the commands of the generated files in dir and reports
+// the differences with the files content.
+// Only the files generated in dir are checked, the other ones being checked with their directory.
+// It returns the number of files that are out of date.
+func checkDir(dir string) (stale int, err error) {
+	cmds, err := generatedFiles(dir)
+	if err != nil {
+		return
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	for _, cmd := range cmds {
+		files, err := cmd.run(dir)
+		if err != nil {
+			fmt.Printf("FAIL\t%s\t%s %s: %v\n", dir, cmd.name, quoteArgs(cmd.args), err)
+			stale += len(cmd.files)
+			continue
+		}
+		for fname := range files {
+			if filepath.Dir(fname) != abs {
+				delete(files, fname)
+			}
+		}
+		n, err := diffFiles(files)
+		if err != nil {
+			return stale, err
+		}
+		stale += n
+	}
+	return
+}
+
+// run runs the command in dir and returns the content of the files it generates
+// keyed by their absolute path.
+func (cmd *genCommand) run(dir string) (map[string][]byte, error) {
+	files, err := runInMemory(dir, cmd.name, cmd.args)
+	if err != nil {
+		return nil, err
+	}
+	if out, ok := files[""]; ok {
+		// The standard output was redirected to the generated file.
+		delete(files, "")
+		for _, fname := range cmd.files {
+			fname, err := filepath.Abs(fname)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := files[fname]; !ok {
+				files[fname] = out
+			}
+		}
+	}
+	return files, nil
+}
+
+// runInMemory runs the command with its arguments in dir, and returns the files
+// it generates instead of writing them.
+func runInMemory(dir, name string, args []string) (map[string][]byte, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{}
+	defer func(files map[string][]byte, name string, args []string) {
+		memFiles, cmdName, cmdArgs = files, name, args
+	}(memFiles, cmdName, cmdArgs)
+	memFiles, cmdName, cmdArgs = files, name, args
+
+	err = cli.Parse(args...)
+	if cerr := os.Chdir(wd); err == nil {
+		err = cerr
+	}
+	return files, err
+}
+
+// diffFiles prints the unified diff between the files on disk and their new content.
+// It returns the number of files that differ.
+func diffFiles(files map[string][]byte) (int, error) {
+	fnames := make([]string, 0, len(files))
+	for fname := range files {
+		fnames = append(fnames, fname)
+	}
+	sort.Strings(fnames)
+
+	var n int
+	for _, fname := range fnames {
+		old, err := ioutil.ReadFile(fname)
+		if err != nil && !os.IsNotExist(err) {
+			return n, err
+		}
+		if bytes.Equal(old, files[fname]) {
+			continue
+		}
+		n++
+		name := relPath(fname)
+		os.Stdout.Write(diff.Diff(name, old, name+" (generated)", files[fname]))
+	}
+	return n, nil
+}
+
+// relPath returns the path relative to the working directory if possible.
+func relPath(fname string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return fname
+	}
+	rel, err := filepath.Rel(wd, fname)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return fname
+	}
+	return rel
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/pierrec/packagen"
+)
+
+// tempGen returns a temporary module holding a copy of testdata/gen.
+func tempGen(c *qt.C) string {
+	dir := c.Mkdir()
+	err := filepath.Walk(filepath.Join("testdata", "gen"), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(filepath.Join("testdata", "gen"), path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fname := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(fname, data, 0644)
+	})
+	c.Assert(err, qt.IsNil)
+	err = os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/gen\n\ngo 1.21\n"), 0644)
+	c.Assert(err, qt.IsNil)
+	return dir
+}
+
+// captureStdout returns what f writes to the standard output.
+func captureStdout(c *qt.C, f func()) string {
+	r, w, err := os.Pipe()
+	c.Assert(err, qt.IsNil)
+	out := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		_, _ = io.Copy(&buf, r)
+		out <- buf.String()
+	}()
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	f()
+	w.Close()
+	return <-out
+}
+
+func TestCheck(t *testing.T) {
+	c := qt.New(t)
+
+	// The generated files of testdata are up to date.
+	var stale int
+	var err error
+	captureStdout(c, func() { stale, err = checkDir(filepath.Join("testdata", "gen")) })
+	c.Assert(err, qt.IsNil)
+	c.Assert(stale, qt.Equals, 0)
+
+	// Edited generated files are reported with their differences.
+	dir := tempGen(c)
+	fname := filepath.Join(dir, "list_int.go")
+	data, err := os.ReadFile(fname)
+	c.Assert(err, qt.IsNil)
+	data = bytes.Replace(data, []byte("s += it"), []byte("s -= it"), 1)
+	c.Assert(os.WriteFile(fname, data, 0644), qt.IsNil)
+
+	out := captureStdout(c, func() { stale, err = checkDir(dir) })
+	c.Assert(err, qt.IsNil)
+	c.Assert(stale, qt.Equals, 1)
+	c.Assert(strings.Contains(out, "-\t\ts -= it\n+\t\ts += it\n"), qt.Equals, true, qt.Commentf("%s", out))
+
+	// Files whose command fails are reported as out of date.
+	c.Assert(os.Remove(filepath.Join(dir, "tmpl", "list.go")), qt.IsNil)
+	packagen.Invalidate(filepath.Join(dir, "tmpl"))
+	out = captureStdout(c, func() { stale, err = checkDir(dir) })
+	c.Assert(err, qt.IsNil)
+	c.Assert(stale, qt.Equals, 1)
+	c.Assert(out, qt.Matches, `(?s)FAIL\t.*`)
+}
+
+func TestCheckParentManifest(t *testing.T) {
+	c := qt.New(t)
+
+	// The manifest of the parent directory is rerun to check the files it generates.
+	dir := tempGen(c)
+	manifest := `{"bundle": [{"out": "sub/list_int.go", "pkg": "./tmpl", "newpkg": "sub", "prefix": "Int", "types": {"Item": "int"}}]}`
+	c.Assert(os.WriteFile(filepath.Join(dir, manifestName), []byte(manifest), 0644), qt.IsNil)
+	c.Assert(os.Remove(filepath.Join(dir, "list_int.go")), qt.IsNil)
+	c.Assert(os.Mkdir(filepath.Join(dir, "sub"), 0755), qt.IsNil)
+	var failed int
+	var err error
+	out := captureStdout(c, func() { failed, err = genDir(dir) })
+	c.Assert(err, qt.IsNil)
+	c.Assert(failed, qt.Equals, 0, qt.Commentf("%s", out))
+
+	fname := filepath.Join(dir, "sub", "list_int.go")
+	header, err := readHeader(fname)
+	c.Assert(err, qt.IsNil)
+	_, args, ok := parseHeader(header)
+	c.Assert(ok, qt.Equals, true)
+	c.Assert(args, qt.DeepEquals, []string{"gen", ".."})
+
+	var stale int
+	out = captureStdout(c, func() { stale, err = checkDir(filepath.Join(dir, "sub")) })
+	c.Assert(err, qt.IsNil)
+	c.Assert(stale, qt.Equals, 0, qt.Commentf("%s", out))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pierrec/packagen"
+)
+
+// instantiateDirective is the prefix of the comments defining bundle jobs in the Go files
+// of the destination package, e.g.:
+//
+//	//packagen:instantiate example.com/slice Item=Int64 prefix=Int64 out=slice_int64.go
+//
+// The arguments following the package are either options (out, prefix, newpkg, rmtype, const, rmconst,
+// rm, roots, args, inline, tests, check, lines) or types to be renamed, pivot types being removed.
+const instantiateDirective = packagen.DirectivePrefix + "instantiate"
+
+// readDirectives returns the bundle jobs defined by the directives of the Go files in dir.
+// Files that cannot be parsed are skipped.
+func readDirectives(dir string) ([]bundleJob, error) {
+	fnames, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	var jobs []bundleJob
+	fset := token.NewFileSet()
+	for _, fname := range fnames {
+		f, err := parser.ParseFile(fset, fname, nil, parser.ParseComments)
+		if err != nil {
+			continue
+		}
+		for _, cg := range f.Comments {
+			for _, c := range cg.List {
+				args := strings.TrimPrefix(c.Text, instantiateDirective)
+				if args == c.Text || args != "" && args[0] != ' ' && args[0] != '\t' {
+					continue
+				}
+				job, err := parseDirective(args)
+				if err != nil {
+					return nil, fmt.Errorf("%v: %v", fset.Position(c.Pos()), err)
+				}
+				jobs = append(jobs, job)
+			}
+		}
+	}
+	return jobs, nil
+}
+
+// parseDirective parses the arguments of an instantiate directive.
+func parseDirective(s string) (job bundleJob, err error) {
+	args, err := splitArgs(s)
+	if err != nil {
+		return
+	}
+	if len(args) == 0 {
+		err = errMissingPkg
+		return
+	}
+	job.Pkg = args[0]
+	o := &job.BundleOption
+	for _, arg := range args[1:] {
+		i := strings.IndexByte(arg, typeSep)
+		if i <= 0 {
+			err = fmt.Errorf("invalid argument %q: must be key%cvalue", arg, typeSep)
+			return
+		}
+		key, value := arg[:i], arg[i+1:]
+		switch key {
+		case "out":
+			job.Out = value
+		case "prefix":
+			o.Prefix = value
+		case "newpkg":
+			o.NewPkg = value
+		case "rmtype":
+			o.RmTypes = toMapBool(value)
+		case "const":
+			o.Const, err = toMapString(value)
+		case "rmconst":
+			o.RmConst = toMapBool(value)
+		case "rm":
+			o.RmFuncs = toMapBool(value)
+			o.RmVars = toMapBool(value)
+			o.RmMethods = toMapBool(value)
+		case "roots":
+			o.Roots = toMapBool(value)
+		case "args":
+			o.TypeArgs, err = toMapString(value)
+		case "inline":
+			o.Inline = toList(value)
+		case "tests":
+			job.Tests, err = strconv.ParseBool(value)
+		case "check":
+			job.Check, err = strconv.ParseBool(value)
+		case "lines":
+			o.Lines, err = strconv.ParseBool(value)
+		default:
+			// Type to be renamed.
+			if o.Types == nil {
+				o.Types = map[string]string{}
+			}
+			o.Types[key] = value
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %v", key, err)
+			return
+		}
+	}
+	if job.Out == "" {
+		err = errMissingOutput
+	}
+	return
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/pierrec/packagen"
+)
+
+func TestParseDirective(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args string
+		job  bundleJob
+		err  string
+	}{
+		{
+			name: "types",
+			args: " example.com/slice Item=Int64 Key=*go/token.File out=slice_int64.go",
+			job: bundleJob{
+				Out: "slice_int64.go",
+				BundleOption: packagen.BundleOption{
+					Pkg:   "example.com/slice",
+					Types: map[string]string{"Item": "Int64", "Key": "*go/token.File"},
+				},
+			},
+		},
+		{
+			name: "options",
+			args: " ./slice out=gen.go prefix=Int newpkg=ints rmtype=Item,Key const=Size=4,Max=8 rmconst=Min" +
+				" rm=debug roots=New args=K=string inline=a/...,b tests=true check=true lines=true",
+			job: bundleJob{
+				Out:   "gen.go",
+				Tests: true,
+				Check: true,
+				BundleOption: packagen.BundleOption{
+					Pkg:       "./slice",
+					Prefix:    "Int",
+					NewPkg:    "ints",
+					RmTypes:   map[string]bool{"Item": true, "Key": true},
+					Const:     map[string]string{"Size": "4", "Max": "8"},
+					RmConst:   map[string]bool{"Min": true},
+					RmFuncs:   map[string]bool{"debug": true},
+					RmVars:    map[string]bool{"debug": true},
+					RmMethods: map[string]bool{"debug": true},
+					Roots:     map[string]bool{"New": true},
+					TypeArgs:  map[string]string{"K": "string"},
+					Inline:    []string{"a/...", "b"},
+					Lines:     true,
+				},
+			},
+		},
+		{
+			name: "quoted",
+			args: ` ./slice "Item=map[string]func(a, b int) error" 'const=Name="a b"' out=gen.go`,
+			job: bundleJob{
+				Out: "gen.go",
+				BundleOption: packagen.BundleOption{
+					Pkg:   "./slice",
+					Types: map[string]string{"Item": "map[string]func(a, b int) error"},
+					Const: map[string]string{"Name": `"a b"`},
+				},
+			},
+		},
+		{
+			name: "missing package",
+			args: "  ",
+			err:  "missing package name",
+		},
+		{
+			name: "missing output",
+			args: " ./slice Item=Int64",
+			err:  "missing output file",
+		},
+		{
+			name: "invalid argument",
+			args: " ./slice Item out=gen.go",
+			err:  `invalid argument "Item": must be key=value`,
+		},
+		{
+			name: "invalid value",
+			args: " ./slice out=gen.go tests=maybe",
+			err:  `tests: .*invalid syntax`,
+		},
+		{
+			name: "unterminated quote",
+			args: ` ./slice "Item=Int64 out=gen.go`,
+			err:  "unterminated quoted string .*",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			job, err := parseDirective(tc.args)
+			if tc.err != "" {
+				c.Assert(err, qt.ErrorMatches, tc.err)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(job, qt.DeepEquals, tc.job)
+		})
+	}
+}
+
+func TestFindJobs(t *testing.T) {
+	c := qt.New(t)
+
+	root := t.TempDir()
+	write := func(name, content string) {
+		fname := filepath.Join(root, name)
+		c.Assert(os.MkdirAll(filepath.Dir(fname), 0755), qt.IsNil)
+		c.Assert(os.WriteFile(fname, []byte(content), 0644), qt.IsNil)
+	}
+	write("ok/ok.go", "package ok\n\n//packagen:instantiate ./slice Item=Int64 out=gen.go\ntype Int64 int64\n")
+	write("ok/broken.go", "package ok\n\nfunc {\n")
+	write("invalid/invalid.go", "package invalid\n\n//packagen:instantiate ./slice Item=Int64\n")
+	write("none/none.go", "package none\n")
+
+	// Unparsable files are skipped.
+	jobs, err := readDirectives(filepath.Join(root, "ok"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(jobs, qt.HasLen, 1)
+
+	// Directories with invalid directives are reported when running their jobs.
+	dirs, err := findJobs([]string{root + "/..."})
+	c.Assert(err, qt.IsNil)
+	c.Assert(dirs, qt.DeepEquals, []string{filepath.Join(root, "invalid"), filepath.Join(root, "ok")})
+
+	_, err = findJobs([]string{filepath.Join(root, "none")})
+	c.Assert(err, qt.ErrorMatches, ".*: no packagen.json file nor //packagen:instantiate directive")
+}
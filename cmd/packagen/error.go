@@ -7,6 +7,7 @@ func (e errorString) Error() string {
 }
 
 const (
-	errMissingPkg errorString = "missing package name"
-	errTooManyPkg errorString = "too many packages"
+	errMissingPkg    errorString = "missing package name"
+	errTooManyPkg    errorString = "too many packages"
+	errMissingOutput errorString = "missing output file"
 )
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"path/filepath"
 	"strings"
 
 	"github.com/pierrec/cmdflag"
@@ -22,42 +23,65 @@ func init() {
 
 			set.StringVar(&o.SrcPkg, "pkg", "", "source package name")
 			set.StringVar(&o.Src, "src", "", "source type name")
+			set.StringVar(&o.DstPkg, "dstpkg", ".", "package of the extended type")
 			set.StringVar(&o.Dst, "tgt", "", "extended type name")
 			set.StringVar(&o.FieldPrefix, "fprefix", "", "field prefix")
 			set.StringVar(&o.MethodPrefix, "mprefix", "", "method prefix")
+			set.BoolVar(&o.Lines, "lines", false, "emit //line directives pointing to the source methods")
+			set.BoolVar(&o.Flatten, "flatten", false, "copy the fields of the embedded structs instead of embedding them")
 
 			var fields string
 			set.StringVar(&fields, "fields", "",
-				fmt.Sprintf("list of field names to their type: name%ctype[%c ...]",
+				fmt.Sprintf("list of field names, or embedded type names, to their type: name%ctype[%c ...]",
 					typeSep, listSep))
 
-			return func(args ...string) (_ int, err error) {
+			var typeNames string
+			set.StringVar(&typeNames, "types", "",
+				fmt.Sprintf("list of type names, qualified by their import path if from another package, to their new type: name%ctype[%c ...]",
+					typeSep, listSep))
+
+			plugins := pluginFlag(set)
+
+			return dryRun(set, func(args ...string) (_ int, err error) {
+				o.Transformers = plugins()
 				o.Fields, err = toMapString(fields)
 				if err != nil {
 					return
 				}
-				var buf, methods bytes.Buffer
-				fname, err := packagen.ExtendStruct(&buf, &methods, o)
+				o.Types, err = toMapString(typeNames)
 				if err != nil {
 					return
 				}
-				// Write the updated type.
-				if err = extendWriteFile(fname, &buf); err != nil {
-					return
-				}
-				// Write the type methods.
-				if methods.Len() > 0 {
-					mname := fmt.Sprintf("%s_gen.go", strings.TrimSuffix(fname, ".go"))
-					err = extendWriteFile(mname, &methods)
-				}
+				err = extendFiles(o, func(string) []string { return cmdArgs })
 				return
-			}
+			})
 		},
 	})
 }
 
-func extendWriteFile(name string, buf *bytes.Buffer) error {
-	out, err := initOutput(name, false, false)
+// extendFiles extends the destination type and writes the results to their files,
+// the methods file header recording the arguments returned by args for its directory.
+func extendFiles(o packagen.ExtendOption, args func(dir string) []string) error {
+	var buf, methods bytes.Buffer
+	fname, err := packagen.ExtendStruct(&buf, &methods, o)
+	if err != nil {
+		return err
+	}
+	// Write the updated type.
+	if err := extendWriteFile(fname, nil, &buf); err != nil {
+		return err
+	}
+	// Write the type methods.
+	if methods.Len() > 0 {
+		mname := fmt.Sprintf("%s_gen.go", strings.TrimSuffix(fname, ".go"))
+		return extendWriteFile(mname, args(filepath.Dir(mname)), &methods)
+	}
+	return nil
+}
+
+func extendWriteFile(name string, args []string, buf *bytes.Buffer) error {
+	// Extending rewrites the destination file: do not add the generate directive.
+	out, err := initOutput(name, args, true)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pierrec/cmdflag"
+	"github.com/pierrec/packagen"
+)
+
+// manifestName is the name of the files defining the jobs run by the gen command.
+const manifestName = "packagen.json"
+
+// manifest defines the jobs for the directory it is located in.
+// Only JSON manifests are supported, field names being matched case insensitively, e.g.:
+//
+//	{
+//		"bundle": [{"out": "slice_int64.go", "pkg": "./slice", "prefix": "Int64",
+//			"types": {"Item": "Int64"}, "rmtypes": {"Item": true}}],
+//		"extend": [{"srcpkg": "./src", "src": "Data", "dstpkg": ".", "dst": "ExData"}]
+//	}
+type manifest struct {
+	Bundle []bundleJob
+	Extend []packagen.ExtendOption
+}
+
+// bundleJob defines the package to be bundled and the file it is written to.
+type bundleJob struct {
+	Out   string // Output file
+	Tests bool   // Also bundle the tests into the output file suffixed with _test
+	Check bool   // Type check the output files before writing them
+	packagen.BundleOption
+}
+
+func init() {
+	cli.MustAdd(cmdflag.Application{
+		Name:  "gen",
+		Descr: fmt.Sprintf("run the jobs defined in the %s files and the %s directives", manifestName, instantiateDirective),
+		Args:  "list of directories, ending with /... to include their subdirectories (default=.)",
+		Err:   flag.ExitOnError,
+		Init: func(set *flag.FlagSet) cmdflag.Handler {
+			return dryRun(set, func(args ...string) (int, error) {
+				patterns := args
+				if len(patterns) == 0 {
+					patterns = []string{"."}
+				}
+				dirs, err := findJobs(patterns)
+				if err != nil {
+					return 0, err
+				}
+				var failed int
+				for _, dir := range dirs {
+					n, err := genDir(dir)
+					if err != nil {
+						fmt.Printf("FAIL\t%s\t%v\n", dir, err)
+						n = 1
+					}
+					failed += n
+				}
+				if failed > 0 {
+					return 0, fmt.Errorf("%d job(s) failed", failed)
+				}
+				return len(args), nil
+			})
+		},
+	})
+}
+
+// findJobs returns the directories matching the patterns and containing a manifest
+// or instantiate directives, or invalid ones reported when running their jobs.
+func findJobs(patterns []string) ([]string, error) {
+	var dirs []string
+	for _, pattern := range patterns {
+		matches, err := matchDirs([]string{pattern})
+		if err != nil {
+			return nil, err
+		}
+		single := !strings.HasSuffix(pattern, "...")
+		for _, dir := range matches {
+			if _, err := os.Stat(filepath.Join(dir, manifestName)); err == nil {
+				dirs = append(dirs, dir)
+				continue
+			}
+			jobs, err := readDirectives(dir)
+			switch {
+			case err != nil, len(jobs) > 0:
+				dirs = append(dirs, dir)
+			case single:
+				return nil, fmt.Errorf("%s: no %s file nor %s directive", dir, manifestName, instantiateDirective)
+			}
+		}
+	}
+	return dirs, nil
+}
+
+// matchDirs returns the directories matching the patterns.
+// Patterns ending with /... match the directory and its subdirectories,
+// except the ones ignored by the go tool.
+func matchDirs(patterns []string) ([]string, error) {
+	var dirs []string
+	for _, pattern := range patterns {
+		root := strings.TrimSuffix(pattern, "...")
+		if root == pattern {
+			dirs = append(dirs, pattern)
+			continue
+		}
+		if root == "" {
+			root = "."
+		}
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				return nil
+			}
+			// Skip the directories ignored by the go tool.
+			switch name := info.Name(); {
+			case filepath.Clean(path) == filepath.Clean(root):
+			case name == "testdata", name == "vendor",
+				strings.HasPrefix(name, "."), strings.HasPrefix(name, "_"):
+				return filepath.SkipDir
+			}
+			dirs = append(dirs, path)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dirs, nil
+}
+
+// genDir runs the jobs of the manifest and of the instantiate directives in dir,
+// and returns the number of failed jobs.
+func genDir(dir string) (failed int, err error) {
+	m := new(manifest)
+	if _, serr := os.Stat(filepath.Join(dir, manifestName)); serr == nil {
+		m, err = readManifest(filepath.Join(dir, manifestName))
+		if err != nil {
+			return
+		}
+	}
+	jobs, err := readDirectives(dir)
+	if err != nil {
+		return
+	}
+	m.Bundle = append(m.Bundle, jobs...)
+
+	// Jobs are relative to the manifest directory.
+	wd, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	if err = os.Chdir(dir); err != nil {
+		return
+	}
+	defer func() {
+		if cerr := os.Chdir(wd); err == nil {
+			err = cerr
+		}
+	}()
+
+	report := func(job string, err error) {
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL\t%s\t%s: %v\n", dir, job, err)
+			return
+		}
+		fmt.Printf("ok\t%s\t%s\n", dir, job)
+	}
+	run := func(check bool, overlay map[string][]byte, report func(job string, err error)) {
+		for _, job := range m.Bundle {
+			job.Log = newLogger()
+			cfg := bundleConfig{
+				args:    genArgs(filepath.Dir(job.Out)),
+				nogen:   true,
+				tests:   job.Tests,
+				check:   check && job.Check,
+				overlay: overlay,
+			}
+			err := bundleFile(job.Out, cfg, job.BundleOption)
+			report("bundle "+job.Out, err)
+		}
+		for _, o := range m.Extend {
+			o.Log = newLogger()
+			if o.DstPkg == "" {
+				o.DstPkg = "."
+			}
+			err := extendFiles(o, genArgs)
+			report("extend "+o.Dst, err)
+		}
+	}
+	var overlay map[string][]byte
+	for _, job := range m.Bundle {
+		if job.Check {
+			// The jobs outputs are type checked along each other, errors being reported
+			// when writing the files.
+			overlay = outputs(func() { run(false, nil, func(string, error) {}) })
+			break
+		}
+	}
+	run(true, overlay, report)
+	return
+}
+
+// genArgs returns the gen command arguments recorded in the header of the files
+// generated in outdir by the jobs of the current directory, so that check and watch
+// rerun them from there.
+func genArgs(outdir string) []string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return []string{"gen"}
+	}
+	outdir, err = filepath.Abs(outdir)
+	if err != nil {
+		return []string{"gen"}
+	}
+	rel, err := filepath.Rel(outdir, wd)
+	if err != nil || rel == "." {
+		return []string{"gen"}
+	}
+	return []string{"gen", filepath.ToSlash(rel)}
+}
+
+// readManifest reads and decodes the manifest file.
+func readManifest(fname string) (*manifest, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := new(manifest)
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(m); err != nil {
+		return nil, fmt.Errorf("%s: %v", fname, err)
+	}
+	for i, job := range m.Bundle {
+		if job.Out == "" {
+			return nil, fmt.Errorf("%s: bundle job #%d: missing output file", fname, i+1)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+var update = flag.Bool("update", false, "update the generated files of testdata")
+
+func TestReadManifest(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		manifest string
+		err      string
+	}{
+		{
+			name:     "valid",
+			manifest: `{"Bundle": [{"Out": "gen.go", "Pkg": "./slice", "Types": {"Item": "int64"}}], "Extend": [{"Src": "A", "Dst": "B"}]}`,
+		},
+		{
+			name:     "bundle log",
+			manifest: `{"Bundle": [{"Out": "gen.go", "Pkg": "./slice", "Log": {}}]}`,
+			err:      `.*: json: unknown field "Log"`,
+		},
+		{
+			name:     "bundle transformers",
+			manifest: `{"Bundle": [{"Out": "gen.go", "Pkg": "./slice", "Transformers": []}]}`,
+			err:      `.*: json: unknown field "Transformers"`,
+		},
+		{
+			name:     "extend transformers",
+			manifest: `{"Extend": [{"Src": "A", "Dst": "B", "Transformers": []}]}`,
+			err:      `.*: json: unknown field "Transformers"`,
+		},
+		{
+			name:     "missing output",
+			manifest: `{"Bundle": [{"Pkg": "./slice"}]}`,
+			err:      `.*: bundle job #1: missing output file`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			fname := filepath.Join(t.TempDir(), manifestName)
+			c.Assert(os.WriteFile(fname, []byte(tc.manifest), 0644), qt.IsNil)
+			_, err := readManifest(fname)
+			if tc.err != "" {
+				c.Assert(err, qt.ErrorMatches, tc.err)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+		})
+	}
+}
+
+func TestGen(t *testing.T) {
+	c := qt.New(t)
+
+	files, err := runInMemory(filepath.Join("testdata", "gen"), "packagen", []string{"gen"})
+	c.Assert(err, qt.IsNil)
+	fname, err := filepath.Abs(filepath.Join("testdata", "gen", "list_int.go"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(files, qt.HasLen, 1)
+	out, ok := files[fname]
+	c.Assert(ok, qt.Equals, true)
+	// The header records the command rerun by check and watch.
+	c.Assert(strings.HasPrefix(string(out), headerLine+"\n"+headerNoGen+"packagen gen\n"), qt.Equals, true)
+
+	if *update {
+		c.Assert(os.WriteFile(fname, out, 0644), qt.IsNil)
+	}
+	want, err := os.ReadFile(fname)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(out), qt.Equals, string(want))
+}
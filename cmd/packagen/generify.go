@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/pierrec/cmdflag"
+	"github.com/pierrec/packagen"
+)
+
+func init() {
+	cli.MustAdd(cmdflag.Application{
+		Name:  "generify",
+		Descr: "convert a package written with pivot types into generic code",
+		Args:  "package to be processed",
+		Err:   flag.ExitOnError,
+		Init: func(set *flag.FlagSet) cmdflag.Handler {
+			o := packagen.GenerifyOption{Log: newLogger()}
+			var nogen bool
+			set.BoolVar(&nogen, "nogen", false, "do not add the generate directive")
+			set.StringVar(&o.NewPkg, "newpkg", "",
+				"new package name (default=processed package name)")
+			var pivots, outfile string
+			set.StringVar(&pivots, "pivot", "",
+				fmt.Sprintf("list of pivot types to be replaced by a type parameter: pivot%cparam[%c ...]", typeSep, listSep))
+			set.StringVar(&outfile, "o", "", "write output to `file` (default=standard output)")
+
+			return dryRun(set, func(args ...string) (_ int, err error) {
+				switch len(args) {
+				case 1:
+				case 0:
+					err = errMissingPkg
+					return
+				default:
+					err = errTooManyPkg
+					return
+				}
+				o.Pkg = args[0]
+				o.Pivots, err = toMapString(pivots)
+				if err != nil {
+					return
+				}
+
+				out, err := initOutput(outfile, cmdArgs, nogen)
+				if err != nil {
+					return
+				}
+				if err = packagen.Generify(out, o); err != nil {
+					if f, ok := out.(*safeFile); ok {
+						// Leave the existing file untouched.
+						_ = f.Cleanup()
+					}
+					return
+				}
+				return len(args), out.Close()
+			})
+		},
+	})
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/pierrec/cmdflag"
 )
@@ -13,6 +15,12 @@ var cli = cmdflag.New(nil)
 
 var verbose bool
 
+// Command name and arguments recorded in the generated files.
+var (
+	cmdName = strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	cmdArgs = os.Args[1:]
+)
+
 func newLogger() *log.Logger {
 	if !verbose {
 		return nil
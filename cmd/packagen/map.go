@@ -2,20 +2,57 @@ package main
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
 )
 
 const (
-	listSep       = ','
-	listSepString = string(listSep)
-	typeSep       = '='
+	listSep = ','
+	typeSep = '='
 )
 
+// splitList splits src at each list separator that is not enclosed
+// in parentheses, brackets, braces or quotes, so that values can be
+// Go expressions (e.g. map[K]V or func(a, b int)).
+func splitList(src string) []string {
+	var list []string
+	var depth int
+	var quote byte
+	var start int
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case quote != 0:
+			switch {
+			case c == '\\' && quote != '`':
+				i++
+			case c == quote:
+				quote = 0
+			}
+		case c == '"', c == '\'', c == '`':
+			quote = c
+		case c == '(', c == '[', c == '{':
+			depth++
+		case c == ')', c == ']', c == '}':
+			depth--
+		case c == listSep && depth == 0:
+			list = append(list, src[start:i])
+			start = i + 1
+		}
+	}
+	return append(list, src[start:])
+}
+
+func toList(src string) []string {
+	if src == "" {
+		return nil
+	}
+	return splitList(src)
+}
+
 func toMapBool(src string) map[string]bool {
 	m := map[string]bool{}
 	if src != "" {
-		for _, s := range strings.Split(src, listSepString) {
+		for _, s := range splitList(src) {
 			m[s] = true
 		}
 	}
@@ -28,7 +65,7 @@ func toMapString(src string) (map[string]string, error) {
 		return m, nil
 	}
 
-	for _, kv := range strings.Split(src, listSepString) {
+	for _, kv := range splitList(src) {
 		i := strings.IndexByte(kv, typeSep)
 		if i < 0 {
 			return nil, fmt.Errorf("missing separator %c in %s", typeSep, kv)
@@ -39,23 +76,14 @@ func toMapString(src string) (map[string]string, error) {
 	return m, nil
 }
 
-func toMapInt(src string) (map[string]int, error) {
-	m := map[string]int{}
-	if src == "" {
-		return m, nil
-	}
+// stringList is a flag.Value accumulating its values.
+type stringList []string
 
-	for _, kv := range strings.Split(src, listSepString) {
-		i := strings.IndexByte(kv, typeSep)
-		if i < 0 {
-			return nil, fmt.Errorf("missing separator %c in %s", typeSep, kv)
-		}
-		n, err := strconv.Atoi(kv[i+1:])
-		if err != nil {
-			return nil, err
-		}
-		m[kv[:i]] = n
-	}
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
 
-	return m, nil
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
 }
@@ -0,0 +1,16 @@
+package tmpl
+
+// Item is the type of the listed values.
+type Item int64
+
+// List is a list of items.
+type List []Item
+
+// Sum returns the sum of the items.
+func (l List) Sum() Item {
+	var s Item
+	for _, it := range l {
+		s += it
+	}
+	return s
+}
@@ -2,13 +2,20 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/google/renameio"
+	"github.com/pierrec/cmdflag"
+	"github.com/pierrec/packagen"
 )
 
 // Make bufio.Writer implement io.Close.
@@ -40,9 +47,34 @@ func (so *safeFile) Close() error {
 	return so.PendingFile.Cleanup()
 }
 
+// memFiles, if not nil, receives the content of the output files instead of the disk
+// or the standard output, keyed by their absolute path ("" for the standard output).
+var memFiles map[string][]byte
+
+// Provide an io.WriteCloser saving its content to memFiles.
+type memFile struct {
+	bytes.Buffer
+	name string
+}
+
+func (m *memFile) Close() error {
+	memFiles[m.name] = m.Bytes()
+	return nil
+}
+
 // Either return the file or a buffered stdout.
-func initOutput(fname string, header, nogen bool) (out io.WriteCloser, err error) {
-	if fname == "" {
+// If args is not nil, the header recording the command arguments is written.
+func initOutput(fname string, args []string, nogen bool) (out io.WriteCloser, err error) {
+	if memFiles != nil {
+		name := fname
+		if name != "" {
+			name, err = filepath.Abs(fname)
+			if err != nil {
+				return
+			}
+		}
+		out = &memFile{name: name}
+	} else if fname == "" {
 		// Buffer standard output.
 		out = &buffer{bufio.NewWriter(os.Stdout)}
 	} else {
@@ -51,20 +83,212 @@ func initOutput(fname string, header, nogen bool) (out io.WriteCloser, err error
 			return
 		}
 	}
-	if !header {
+	if args == nil {
 		return
 	}
-	// Write the header.
-	_, err = fmt.Fprintf(out, "// DO NOT EDIT Code automatically generated.\n")
-	if err != nil {
+	_, err = io.WriteString(out, header(args, nogen))
+	return
+}
+
+// header returns the header of the generated files recording the command arguments.
+func header(args []string, nogen bool) string {
+	if nogen {
+		return fmt.Sprintf("%s\n%s%s %s\n\n", headerLine, headerNoGen, cmdName, quoteArgs(args))
+	}
+	return fmt.Sprintf("%s\n%s%s %s\n\n", headerLine, headerGen, cmdName, quoteArgs(args))
+}
+
+// Generated files header.
+const (
+	headerLine  = "// DO NOT EDIT Code automatically generated."
+	headerGen   = "//go:generate "
+	headerNoGen = "// Generated by: "
+)
+
+// parseHeader returns the command name and arguments recorded in the header.
+// It returns false if the header is not one written by initOutput.
+func parseHeader(header []byte) (name string, args []string, ok bool) {
+	lines := strings.SplitN(string(header), "\n", 3)
+	if len(lines) < 2 || lines[0] != headerLine {
 		return
 	}
-	cmd := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
-	args := strings.Join(os.Args[1:], " ")
-	if nogen {
-		_, err = fmt.Fprintf(out, "// Generated by: %s %s\n\n", cmd, args)
-	} else {
-		_, err = fmt.Fprintf(out, "//go:generate %s %s\n\n", cmd, args)
+	cmd := lines[1]
+	switch {
+	case strings.HasPrefix(cmd, headerGen):
+		cmd = cmd[len(headerGen):]
+	case strings.HasPrefix(cmd, headerNoGen):
+		cmd = cmd[len(headerNoGen):]
+	default:
+		return
+	}
+	args, err := splitArgs(cmd)
+	if err != nil || len(args) == 0 {
+		return
+	}
+	n := 1
+	if len(args) > 2 && args[0] == "go" && args[1] == "run" {
+		// go run path/to/packagen
+		n = 3
+	}
+	return strings.Join(args[:n], " "), args[n:], true
+}
+
+// splitArgs splits the space separated arguments, unquoting the double quoted ones
+// as go generate does. Single quoted arguments are taken verbatim.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		if s[0] == '\'' {
+			i := strings.IndexByte(s[1:], '\'')
+			if i < 0 {
+				return nil, fmt.Errorf("unterminated quoted string in %s", s)
+			}
+			args = append(args, s[1:i+1])
+			s = s[i+2:]
+			continue
+		}
+		if s[0] != '"' {
+			i := strings.IndexAny(s, " \t")
+			if i < 0 {
+				i = len(s)
+			}
+			args = append(args, s[:i])
+			s = s[i:]
+			continue
+		}
+		// Quoted argument.
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' {
+				i++
+			}
+		}
+		if i >= len(s) {
+			return nil, fmt.Errorf("unterminated quoted string in %s", s)
+		}
+		arg, err := strconv.Unquote(s[:i+1])
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		s = s[i+1:]
+	}
+	return args, nil
+}
+
+// quoteArgs joins the arguments, quoting the ones containing spaces
+// as expected by go generate.
+func quoteArgs(args []string) string {
+	s := make([]string, len(args))
+	for i, arg := range args {
+		if strings.ContainsAny(arg, " \t\"") || strings.HasPrefix(arg, "'") {
+			arg = strconv.Quote(arg)
+		}
+		s[i] = arg
+	}
+	return strings.Join(s, " ")
+}
+
+// dryRun registers the -n and -diff flags on the command flag set and returns
+// the handler running h without writing any file when either of them is set:
+// the files that would be written are listed (-n) or their changes printed
+// as a unified diff (-diff).
+func dryRun(set *flag.FlagSet, h cmdflag.Handler) cmdflag.Handler {
+	var list, showDiff bool
+	set.BoolVar(&list, "n", false, "list the files that would be written without writing them")
+	set.BoolVar(&showDiff, "diff", false, "print the changes as a unified diff without writing the files")
+
+	return func(args ...string) (int, error) {
+		if !list && !showDiff || memFiles != nil {
+			return h(args...)
+		}
+		files := map[string][]byte{}
+		defer func(args []string) {
+			memFiles, cmdArgs = nil, args
+		}(cmdArgs)
+		// The generated files must not record the dry run flags.
+		memFiles, cmdArgs = files, removeFlags(cmdArgs, "n", "diff")
+
+		n, err := h(args...)
+		if err != nil {
+			return n, err
+		}
+		if out, ok := files[""]; ok {
+			// Standard output is not clobbered.
+			delete(files, "")
+			if _, err := os.Stdout.Write(out); err != nil {
+				return n, err
+			}
+		}
+		if showDiff {
+			_, err = diffFiles(files)
+			return n, err
+		}
+		return n, listFiles(files)
+	}
+}
+
+// removeFlags returns args without the given boolean flags.
+func removeFlags(args []string, flags ...string) []string {
+	res := make([]string, 0, len(args))
+	for _, arg := range args {
+		name := strings.TrimLeft(arg, "-")
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name = name[:i]
+		}
+		var found bool
+		if strings.HasPrefix(arg, "-") {
+			for _, f := range flags {
+				if name == f {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			res = append(res, arg)
+		}
+	}
+	return res
+}
+
+// listFiles prints the files that would be created or updated with their new content.
+func listFiles(files map[string][]byte) error {
+	fnames := make([]string, 0, len(files))
+	for fname := range files {
+		fnames = append(fnames, fname)
+	}
+	sort.Strings(fnames)
+
+	for _, fname := range fnames {
+		old, err := ioutil.ReadFile(fname)
+		switch {
+		case os.IsNotExist(err):
+			fmt.Printf("create\t%s\n", relPath(fname))
+		case err != nil:
+			return err
+		case bytes.Equal(old, files[fname]):
+			fmt.Printf("same\t%s\n", relPath(fname))
+		default:
+			fmt.Printf("update\t%s\n", relPath(fname))
+		}
+	}
+	return nil
+}
+
+// pluginFlag registers the -plugin flag and returns the function building the
+// transformers running the plugins.
+func pluginFlag(set *flag.FlagSet) func() []packagen.Transformer {
+	var plugins stringList
+	set.Var(&plugins, "plugin",
+		fmt.Sprintf("run the %s<name> executable to transform the package: name[%cparam] (repeatable)",
+			packagen.PluginPrefix, typeSep))
+	return func() []packagen.Transformer {
+		var list []packagen.Transformer
+		for _, p := range plugins {
+			name, param, _ := strings.Cut(p, string(typeSep))
+			list = append(list, packagen.Plugin(name, param))
+		}
+		return list
 	}
-	return
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestDryRun(t *testing.T) {
+	c := qt.New(t)
+
+	defer func(name string, args []string) { cmdName, cmdArgs = name, args }(cmdName, cmdArgs)
+	cmdName = "packagen"
+	dir := tempGen(c)
+	fname := filepath.Join(dir, "list_int.go")
+
+	// Up to date files.
+	var err error
+	out := captureStdout(c, func() { err = cli.Parse("gen", "-n", dir) })
+	c.Assert(err, qt.IsNil)
+	c.Assert(strings.Contains(out, "same\t"+fname+"\n"), qt.Equals, true, qt.Commentf("%s", out))
+
+	// New files are listed but not written.
+	c.Assert(os.Remove(fname), qt.IsNil)
+	out = captureStdout(c, func() { err = cli.Parse("gen", "-n", dir) })
+	c.Assert(err, qt.IsNil)
+	c.Assert(strings.Contains(out, "create\t"+fname+"\n"), qt.Equals, true, qt.Commentf("%s", out))
+	_, err = os.Stat(fname)
+	c.Assert(os.IsNotExist(err), qt.Equals, true)
+
+	// Changes are printed as a unified diff, the header not recording the dry run flags.
+	wd, err := os.Getwd()
+	c.Assert(err, qt.IsNil)
+	c.Assert(os.Chdir(dir), qt.IsNil)
+	defer os.Chdir(wd)
+	cmdArgs = []string{"bundle", "-diff", "-newpkg", "gen", "-prefix", "Int", "-mvtype", "Item=int", "-o", "list_int.go", "./tmpl"}
+	out = captureStdout(c, func() { err = cli.Parse(cmdArgs...) })
+	c.Assert(err, qt.IsNil)
+	c.Assert(strings.Contains(out, "+++ list_int.go (generated)\n"), qt.Equals, true, qt.Commentf("%s", out))
+	c.Assert(strings.Contains(out, "+//go:generate packagen bundle -newpkg gen -prefix Int -mvtype Item=int -o list_int.go ./tmpl\n"),
+		qt.Equals, true, qt.Commentf("%s", out))
+	c.Assert(strings.Contains(out, "+func (l IntList) Sum() int {\n"), qt.Equals, true, qt.Commentf("%s", out))
+	c.Assert(strings.Contains(out, "-diff"), qt.Equals, false)
+	_, err = os.Stat(fname)
+	c.Assert(os.IsNotExist(err), qt.Equals, true)
+}
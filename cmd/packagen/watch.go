@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pierrec/cmdflag"
+	"github.com/pierrec/packagen"
+)
+
+func init() {
+	cli.MustAdd(cmdflag.Application{
+		Name:  "watch",
+		Descr: "regenerate the generated files whenever their packages change",
+		Args:  "list of directories, ending with /... to include their subdirectories (default=.)",
+		Err:   flag.ExitOnError,
+		Init: func(set *flag.FlagSet) cmdflag.Handler {
+			var interval time.Duration
+			set.DurationVar(&interval, "interval", 250*time.Millisecond, "polling interval")
+
+			return func(args ...string) (int, error) {
+				patterns := args
+				if len(patterns) == 0 {
+					patterns = []string{"."}
+				}
+				dirs, err := matchDirs(patterns)
+				if err != nil {
+					return 0, err
+				}
+				return len(args), watch(dirs, interval)
+			}
+		},
+	})
+}
+
+// watch polls the directories containing generated files and the ones of the packages
+// they are generated from, regenerating the files when any of them changes.
+// It only returns on error.
+func watch(dirs []string, interval time.Duration) error {
+	w := newWatcher(dirs)
+	// Load the packages.
+	if err := w.regenerate(nil); err != nil {
+		return err
+	}
+	snap := snapshot(dirs, w.generated)
+	for {
+		time.Sleep(interval)
+		cur := snapshot(dirs, w.generated)
+		changed := changedDirs(snap, cur)
+		// Changes made during the regeneration are caught by the next snapshot.
+		snap = cur
+		if len(changed) == 0 {
+			continue
+		}
+		packagen.Invalidate(changed...)
+		if err := w.regenerate(changed); err != nil {
+			return err
+		}
+	}
+}
+
+// watcher regenerates the generated files of the watched directories.
+type watcher struct {
+	dirs      []string
+	deps      map[string]map[string]bool // Command to the directories it depends on, nil if it failed
+	generated map[string]bool            // Generated files, ignored by the snapshots
+}
+
+func newWatcher(dirs []string) *watcher {
+	return &watcher{
+		dirs:      dirs,
+		deps:      map[string]map[string]bool{},
+		generated: map[string]bool{},
+	}
+}
+
+// regenerate reruns the commands of the generated files in the watched directories depending
+// on the changed directories, as well as the new and failed ones, and writes the files whose
+// content changed. The commands depend on their directory and the ones of the packages they load.
+// Commands failures, including the type errors, are reported but not returned.
+func (w *watcher) regenerate(changed []string) error {
+	for _, dir := range w.dirs {
+		cmds, err := generatedFiles(dir)
+		if err != nil {
+			return err
+		}
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+	cmds:
+		for _, cmd := range cmds {
+			for _, fname := range cmd.files {
+				if fname, err := filepath.Abs(fname); err == nil {
+					w.generated[fname] = true
+				}
+			}
+			key := abs + ": " + cmd.name + " " + quoteArgs(cmd.args)
+			if deps, ok := w.deps[key]; ok && deps != nil {
+				var affected bool
+				for _, dir := range changed {
+					if deps[dir] {
+						affected = true
+						break
+					}
+				}
+				if !affected {
+					continue cmds
+				}
+			}
+			var files map[string][]byte
+			used, err := packagen.UsedDirs(func() (err error) {
+				files, err = cmd.run(dir)
+				return
+			})
+			if err != nil {
+				w.deps[key] = nil
+				fmt.Printf("FAIL\t%s\t%s %s: %v\n", dir, cmd.name, quoteArgs(cmd.args), err)
+				continue
+			}
+			deps := map[string]bool{abs: true}
+			for _, dir := range used {
+				deps[dir] = true
+			}
+			w.deps[key] = deps
+			for fname, data := range files {
+				if _, _, ok := parseHeader(data); ok {
+					w.generated[fname] = true
+				}
+			}
+			if err := updateFiles(files); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// updateFiles writes the files whose content differs from the one on disk.
+func updateFiles(files map[string][]byte) error {
+	for fname, data := range files {
+		old, err := ioutil.ReadFile(fname)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if bytes.Equal(old, data) {
+			continue
+		}
+		out, err := newSafeFile(fname)
+		if err != nil {
+			return err
+		}
+		if _, err := out.Write(data); err != nil {
+			_ = out.Cleanup()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+		fmt.Printf("ok\t%s\n", relPath(fname))
+	}
+	return nil
+}
+
+// fileState identifies a version of a file.
+type fileState struct {
+	size    int64
+	modTime int64
+}
+
+// snapshot returns the state of the Go files and manifests found in the directories
+// and the ones of the cached packages, keyed by directory and file name.
+// The ignored files, keyed by their absolute path, are left out.
+func snapshot(dirs []string, ignored map[string]bool) map[string]map[string]fileState {
+	snap := map[string]map[string]fileState{}
+	add := func(dir string) {
+		dir, err := filepath.Abs(dir)
+		if err != nil || snap[dir] != nil {
+			return
+		}
+		files := map[string]fileState{}
+		snap[dir] = files
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		for _, info := range infos {
+			name := info.Name()
+			if info.IsDir() || filepath.Ext(name) != ".go" && name != manifestName ||
+				ignored[filepath.Join(dir, name)] {
+				continue
+			}
+			files[name] = fileState{info.Size(), info.ModTime().UnixNano()}
+		}
+	}
+	for _, dir := range dirs {
+		add(dir)
+	}
+	for _, dir := range packagen.CachedDirs() {
+		add(dir)
+	}
+	return snap
+}
+
+// changedDirs returns the directories whose files differ between the snapshots.
+func changedDirs(old, cur map[string]map[string]fileState) []string {
+	var dirs []string
+	for dir, files := range cur {
+		oldFiles, ok := old[dir]
+		if !ok {
+			// New package: it was not used before.
+			continue
+		}
+		if len(files) != len(oldFiles) {
+			dirs = append(dirs, dir)
+			continue
+		}
+		for name, state := range files {
+			if oldState, ok := oldFiles[name]; !ok || oldState != state {
+				dirs = append(dirs, dir)
+				break
+			}
+		}
+	}
+	return dirs
+}
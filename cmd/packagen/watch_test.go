@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/pierrec/packagen"
+)
+
+func TestRegenerate(t *testing.T) {
+	c := qt.New(t)
+
+	dir := tempGen(c)
+	fname := filepath.Join(dir, "list_int.go")
+	want, err := os.ReadFile(fname)
+	c.Assert(err, qt.IsNil)
+	edit := func(fname, old, new string) {
+		data, err := os.ReadFile(fname)
+		c.Assert(err, qt.IsNil)
+		data = bytes.Replace(data, []byte(old), []byte(new), 1)
+		c.Assert(os.WriteFile(fname, data, 0644), qt.IsNil)
+	}
+
+	w := newWatcher([]string{dir})
+	out := captureStdout(c, func() { err = w.regenerate(nil) })
+	c.Assert(err, qt.IsNil)
+	c.Assert(out, qt.Equals, "ok\t.\tbundle list_int.go\n")
+
+	// The generated files are not part of the snapshots, unlike the manifest.
+	snap := snapshot([]string{dir}, w.generated)
+	c.Assert(snap[dir]["list_int.go"], qt.Equals, fileState{})
+	c.Assert(snap[dir][manifestName], qt.Not(qt.Equals), fileState{})
+
+	// The commands not depending on the changed directories are not rerun.
+	edit(fname, "s += it", "s -= it")
+	out = captureStdout(c, func() { err = w.regenerate([]string{c.Mkdir()}) })
+	c.Assert(err, qt.IsNil)
+	c.Assert(out, qt.Equals, "")
+
+	// The ones depending on them are.
+	tmpl := filepath.Join(dir, "tmpl")
+	edit(filepath.Join(tmpl, "list.go"), "// List is a list of items.", "// List is a list of values.")
+	packagen.Invalidate(tmpl)
+	out = captureStdout(c, func() { err = w.regenerate([]string{tmpl}) })
+	c.Assert(err, qt.IsNil)
+	c.Assert(out, qt.Equals, "ok\t.\tbundle list_int.go\nok\t"+fname+"\n")
+	got, err := os.ReadFile(fname)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(got), qt.Equals, string(bytes.Replace(want, []byte("items."), []byte("values."), 1)))
+}
@@ -0,0 +1,254 @@
+package packagen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// condRegion is a conditional section delimited by the if, else (optional) and end directives.
+type condRegion struct {
+	ifPos, elsePos, endPos token.Pos
+	ok                     bool // Condition value
+}
+
+// dropped reports whether the node at pos is in the branch not taken.
+func (r *condRegion) dropped(pos token.Pos) bool {
+	if pos < r.ifPos || pos > r.endPos {
+		return false
+	}
+	if r.elsePos.IsValid() && pos > r.elsePos {
+		return r.ok
+	}
+	return !r.ok
+}
+
+// Conditionals returns the Transformer evaluating the conditional sections of the packages,
+// delimited by the directives
+//
+//	//packagen:if Name=Value [Name!=Value ...]
+//	//packagen:else
+//	//packagen:end
+//
+// around declarations or statements, and dropping the branches not taken.
+// The conditions hold if all the type names (once renamed with types) or constants
+// (once updated with consts) are equal, or not equal, to their value.
+// The directives are removed from the doc comments of the declarations.
+// It must be applied before the declarations are renamed.
+func Conditionals(typeNames, consts map[string]string) Transformer {
+	return TransformerFunc(func(t *Transformation) error {
+		for _, pkg := range t.Pkgs {
+			for _, f := range pkg.Syntax {
+				regions, err := condRegions(pkg, f, typeNames, consts)
+				if err != nil {
+					return err
+				}
+				if len(regions) > 0 {
+					dropBranches(t, pkg.Fset, f, regions)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// condRegions returns the conditional sections of the file with their evaluated condition.
+func condRegions(pkg *packages.Package, f *ast.File, typeNames, consts map[string]string) ([]*condRegion, error) {
+	var regions, stack []*condRegion
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			if !strings.HasPrefix(c.Text, DirectivePrefix) {
+				continue
+			}
+			name, args, _ := strings.Cut(strings.TrimPrefix(c.Text, DirectivePrefix), " ")
+			pos := pkg.Fset.Position(c.Pos())
+			switch name {
+			case "if":
+				ok, err := evalCond(pkg, strings.TrimSpace(args), typeNames, consts)
+				if err != nil {
+					return nil, fmt.Errorf("%v: %v", pos, err)
+				}
+				r := &condRegion{ifPos: c.Pos(), ok: ok}
+				regions = append(regions, r)
+				stack = append(stack, r)
+			case "else":
+				if len(stack) == 0 || stack[len(stack)-1].elsePos.IsValid() {
+					return nil, fmt.Errorf("%v: unexpected %selse", pos, DirectivePrefix)
+				}
+				stack[len(stack)-1].elsePos = c.Pos()
+			case "end":
+				if len(stack) == 0 {
+					return nil, fmt.Errorf("%v: unexpected %send", pos, DirectivePrefix)
+				}
+				stack[len(stack)-1].endPos = c.Pos()
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("%v: missing %send", pkg.Fset.Position(stack[0].ifPos), DirectivePrefix)
+	}
+	return regions, nil
+}
+
+// evalCond evaluates the space separated conditions Name=Value or Name!=Value.
+func evalCond(pkg *packages.Package, conds string, typeNames, consts map[string]string) (bool, error) {
+	if conds == "" {
+		return false, fmt.Errorf("missing %sif condition", DirectivePrefix)
+	}
+	for _, cond := range strings.Fields(conds) {
+		i := strings.IndexByte(cond, '=')
+		if i <= 0 {
+			return false, fmt.Errorf("invalid condition %q: must be Name=Value or Name!=Value", cond)
+		}
+		name, value, neq := cond[:i], cond[i+1:], false
+		if strings.HasSuffix(name, "!") {
+			name, neq = name[:len(name)-1], true
+		}
+		eq, err := evalEqual(pkg, name, value, typeNames, consts)
+		if err != nil {
+			return false, err
+		}
+		if eq == neq {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// evalEqual reports whether the type or constant name is equal to value.
+func evalEqual(pkg *packages.Package, name, value string, typeNames, consts map[string]string) (bool, error) {
+	obj := pkg.Types.Scope().Lookup(name)
+	switch obj := obj.(type) {
+	case *types.TypeName:
+		cur := name
+		if s, ok := typeNames[name]; ok {
+			cur = s
+		}
+		// Compare the normalized type expressions.
+		x, err := parser.ParseExpr(cur)
+		if err != nil {
+			return false, err
+		}
+		y, err := parser.ParseExpr(value)
+		if err != nil {
+			return false, fmt.Errorf("%s: %v", value, err)
+		}
+		return types.ExprString(x) == types.ExprString(y), nil
+	case *types.Const:
+		x := obj.Val()
+		if s, ok := consts[name]; ok {
+			tv, err := types.Eval(pkg.Fset, pkg.Types, token.NoPos, s)
+			if err != nil {
+				return false, fmt.Errorf("const %s: %v", name, err)
+			}
+			x = tv.Value
+		}
+		tv, err := types.Eval(pkg.Fset, pkg.Types, token.NoPos, value)
+		if err != nil {
+			return false, fmt.Errorf("%s: %v", value, err)
+		}
+		y := tv.Value
+		if y == nil {
+			return false, fmt.Errorf("%s is not a constant", value)
+		}
+		if x == nil || y.Kind() != x.Kind() && !(isNumeric(x) && isNumeric(y)) {
+			return false, fmt.Errorf("cannot compare const %s with %s", name, value)
+		}
+		return constant.Compare(x, token.EQL, y), nil
+	}
+	return false, fmt.Errorf("%s is neither a type nor a constant", name)
+}
+
+// isNumeric reports whether the constant value is numeric.
+func isNumeric(v constant.Value) bool {
+	switch v.Kind() {
+	case constant.Int, constant.Float, constant.Complex:
+		return true
+	}
+	return false
+}
+
+// dropBranches drops the declarations and statements of the file in the branches not taken.
+func dropBranches(t *Transformation, fset *token.FileSet, f *ast.File, regions []*condRegion) {
+	dropped := func(n ast.Node) bool {
+		for _, r := range regions {
+			if r.dropped(n.Pos()) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, decl := range f.Decls {
+		if dropped(decl) {
+			t.Remove(decl)
+			continue
+		}
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			stripDirectives(t, &decl.Doc, isCondDirective)
+		case *ast.GenDecl:
+			stripDirectives(t, &decl.Doc, isCondDirective)
+		}
+	}
+	filter := func(list *[]ast.Stmt) bool {
+		var stmts []ast.Stmt
+		for _, stmt := range *list {
+			if !dropped(stmt) {
+				stmts = append(stmts, stmt)
+			}
+		}
+		if len(stmts) == len(*list) {
+			return false
+		}
+		t.Defer(func(stmts []ast.Stmt) func() {
+			return func() { *list = stmts }
+		}(*list))
+		*list = stmts
+		return true
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			if filter(&n.List) && !t.lines {
+				tightenBlock(t, fset, n)
+			}
+		case *ast.CaseClause:
+			filter(&n.Body)
+		case *ast.CommClause:
+			filter(&n.Body)
+		}
+		return true
+	})
+}
+
+// isCondDirective reports whether the directive delimits a conditional section.
+func isCondDirective(name string) bool {
+	return name == "if" || name == "else" || name == "end"
+}
+
+// tightenBlock moves the braces of the block next to its first and last statements,
+// so that no empty lines are left by the dropped statements.
+// It is not used when the lines of the declarations are kept.
+func tightenBlock(t *Transformation, fset *token.FileSet, b *ast.BlockStmt) {
+	if len(b.List) == 0 {
+		return
+	}
+	file := fset.File(b.Pos())
+	first := file.Line(b.List[0].Pos())
+	last := file.Line(b.List[len(b.List)-1].End())
+	lbrace, rbrace := b.Lbrace, b.Rbrace
+	t.Defer(func() { b.Lbrace, b.Rbrace = lbrace, rbrace })
+	if file.Line(lbrace) < first-1 {
+		b.Lbrace = file.LineStart(first - 1)
+	}
+	if file.Line(rbrace) > last+1 {
+		b.Rbrace = file.LineStart(last + 1)
+	}
+}
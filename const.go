@@ -0,0 +1,205 @@
+package packagen
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"reflect"
+	"sort"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// setConsts updates the values of the constants in the packages with the given Go constant expressions,
+// keyed by constant name. The expressions are type checked against the declared constant types
+// and their identifiers renamed with rename.
+// It returns the handler to be called to restore the original values.
+func setConsts(pkgs []*packages.Package, values map[string]string, logger *log.Logger,
+	rename func(*packages.Package, *types.Info)) (done func(), err error) {
+	type saved struct {
+		spec   *ast.ValueSpec
+		typ    ast.Expr
+		values []ast.Expr
+	}
+	var restore []saved
+	undo := func() {
+		for _, s := range restore {
+			s.spec.Type, s.spec.Values = s.typ, s.values
+		}
+	}
+	if len(values) == 0 {
+		return undo, nil
+	}
+	defer func() {
+		if err != nil {
+			undo()
+		}
+	}()
+
+	found := map[string]bool{}
+	for _, pkg := range pkgs {
+		info := pkg.TypesInfo
+		for _, f := range pkg.Syntax {
+			for _, decl := range f.Decls {
+				decl, ok := decl.(*ast.GenDecl)
+				if !ok || decl.Tok != token.CONST {
+					continue
+				}
+				// Values and type repeated by the specs without values (e.g. iota groups).
+				var prevType ast.Expr
+				var prevValues []ast.Expr
+				var updatedPrev bool
+				for _, spec := range decl.Specs {
+					v := spec.(*ast.ValueSpec)
+					implicit := len(v.Values) == 0
+					typ, vals := v.Type, v.Values
+					if implicit {
+						typ, vals = prevType, prevValues
+					} else {
+						prevType, prevValues = v.Type, v.Values
+					}
+
+					var newValues []ast.Expr
+					for i, id := range v.Names {
+						obj, ok := info.Defs[id].(*types.Const)
+						if !ok {
+							continue
+						}
+						s, ok := values[obj.Name()]
+						if !ok {
+							continue
+						}
+						found[obj.Name()] = true
+						expr, einfo, err := constExpr(pkg, decl.Pos(), obj, typ != nil, s)
+						if err != nil {
+							return nil, err
+						}
+						rename(pkg, einfo)
+						setPos(expr, id.End())
+						if newValues == nil {
+							newValues = append([]ast.Expr(nil), vals...)
+						}
+						newValues[i] = expr
+						if logger != nil {
+							logger.Printf("const %s value updated to %s", obj.Name(), s)
+						}
+					}
+					switch {
+					case newValues != nil:
+						restore = append(restore, saved{v, v.Type, v.Values})
+						v.Type, v.Values = typ, newValues
+						updatedPrev = true
+					case implicit && updatedPrev:
+						// Values are not repeated from the updated spec anymore.
+						restore = append(restore, saved{v, v.Type, v.Values})
+						v.Type, v.Values = typ, vals
+						updatedPrev = false
+					default:
+						updatedPrev = false
+					}
+				}
+			}
+		}
+	}
+
+	var missing []string
+	for name := range values {
+		if !found[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("constants not found: %v", missing)
+	}
+	return undo, nil
+}
+
+// constExpr parses and type checks the new value s of the constant obj.
+// If typed is set, the value must be assignable and convertible to the constant type,
+// otherwise it must be of the same kind (boolean, numeric or string) and convertible to
+// the default type of the constant, e.g. int for an untyped integer constant.
+func constExpr(pkg *packages.Package, pos token.Pos, obj *types.Const, typed bool, s string) (ast.Expr, *types.Info, error) {
+	expr, err := parser.ParseExpr(s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("const %s: %v", obj.Name(), err)
+	}
+	info, err := checkExpr(pkg, pos, expr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("const %s: %v", obj.Name(), err)
+	}
+	tv := info.Types[expr]
+	if tv.Value == nil {
+		return nil, nil, fmt.Errorf("const %s: %s is not a constant", obj.Name(), s)
+	}
+	t := obj.Type()
+	if !typed {
+		kind := func(b *types.Basic) types.BasicInfo {
+			if b.Info()&types.IsNumeric != 0 {
+				return types.IsNumeric
+			}
+			return b.Info() & (types.IsBoolean | types.IsString)
+		}
+		vt, ok1 := tv.Type.Underlying().(*types.Basic)
+		ot, ok2 := t.Underlying().(*types.Basic)
+		if !ok1 || !ok2 || kind(vt) == 0 || kind(vt) != kind(ot) {
+			return nil, nil, fmt.Errorf("const %s: cannot use %s (%v) as %v value", obj.Name(), s, tv.Type, t)
+		}
+		t = types.Default(t)
+	} else if !types.AssignableTo(tv.Type, t) {
+		return nil, nil, fmt.Errorf("const %s: cannot use %s (%v) as %v value", obj.Name(), s, tv.Type, t)
+	}
+	// Make sure that the value is representable by the constant type.
+	qualifier := func(p *types.Package) string {
+		if p == pkg.Types {
+			return ""
+		}
+		return p.Name()
+	}
+	conv := fmt.Sprintf("%s(%s)", types.TypeString(t, qualifier), s)
+	cexpr, err := parser.ParseExpr(conv)
+	if err == nil {
+		_, err = checkExpr(pkg, pos, cexpr)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("const %s: %v", obj.Name(), err)
+	}
+	return expr, info, nil
+}
+
+// checkExpr type checks the expression in the innermost scope of the package containing pos.
+func checkExpr(pkg *packages.Package, pos token.Pos, expr ast.Expr) (*types.Info, error) {
+	info := &types.Info{
+		Types: map[ast.Expr]types.TypeAndValue{},
+		Uses:  map[*ast.Ident]types.Object{},
+	}
+	if err := types.CheckExpr(pkg.Fset, pkg.Types, pos, expr, info); err != nil {
+		if terr, ok := err.(types.Error); ok {
+			// The position is not relevant to the expression.
+			return nil, errors.New(terr.Msg)
+		}
+		return nil, err
+	}
+	return info, nil
+}
+
+// setPos sets all the positions of the node to pos.
+func setPos(node ast.Node, pos token.Pos) {
+	posType := reflect.TypeOf(pos)
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		v := reflect.ValueOf(n).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Field(i); f.Type() == posType {
+				f.Set(reflect.ValueOf(pos))
+			}
+		}
+		return true
+	})
+}
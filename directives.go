@@ -0,0 +1,284 @@
+package packagen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// DirectivePrefix is the prefix of the comments annotating the declarations of the templates:
+//
+//	//packagen:pivot   type replaced when instantiating the template, removed once renamed
+//	//packagen:remove  declaration never copied
+//	//packagen:keep    declaration not prefixed
+//	//packagen:const   constant that can be updated, the other ones cannot once set
+//	//packagen:if      conditional section, see Conditionals
+//
+// The directives apply to the declaration or spec whose doc or line comment they are part of.
+const DirectivePrefix = "//packagen:"
+
+// directives holds the directives found in the packages.
+type directives struct {
+	pivots  map[string]bool // Names of the pivot types
+	tunable map[string]bool // Names of the updatable constants
+	keep    map[types.Object]bool
+	remove  map[ast.Node]bool // Removed declarations and specs
+}
+
+// parseDirectives returns the directives of the packages.
+func parseDirectives(pkgs []*packages.Package) (*directives, error) {
+	d := &directives{
+		pivots:  map[string]bool{},
+		tunable: map[string]bool{},
+		keep:    map[types.Object]bool{},
+		remove:  map[ast.Node]bool{},
+	}
+	for _, pkg := range pkgs {
+		info := pkg.TypesInfo
+		for _, f := range pkg.Syntax {
+			for _, decl := range f.Decls {
+				switch decl := decl.(type) {
+				case *ast.FuncDecl:
+					if err := d.add(pkg, decl, []types.Object{info.Defs[decl.Name]}, decl.Doc); err != nil {
+						return nil, err
+					}
+				case *ast.GenDecl:
+					if decl.Tok == token.IMPORT {
+						continue
+					}
+					var objs []types.Object
+					for _, spec := range decl.Specs {
+						var specObjs []types.Object
+						var doc, comment *ast.CommentGroup
+						switch spec := spec.(type) {
+						case *ast.TypeSpec:
+							specObjs = []types.Object{info.Defs[spec.Name]}
+							doc, comment = spec.Doc, spec.Comment
+						case *ast.ValueSpec:
+							for _, id := range spec.Names {
+								specObjs = append(specObjs, info.Defs[id])
+							}
+							doc, comment = spec.Doc, spec.Comment
+						}
+						if err := d.add(pkg, spec, specObjs, doc, comment); err != nil {
+							return nil, err
+						}
+						objs = append(objs, specObjs...)
+					}
+					if err := d.add(pkg, decl, objs, decl.Doc); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+	return d, nil
+}
+
+// add records the directives found in the comments for the declaration or spec node
+// declaring the objects.
+func (d *directives) add(pkg *packages.Package, node ast.Node, objs []types.Object, comments ...*ast.CommentGroup) error {
+	for _, cg := range comments {
+		if cg == nil {
+			continue
+		}
+		for _, c := range cg.List {
+			if !strings.HasPrefix(c.Text, DirectivePrefix) {
+				continue
+			}
+			name, _, _ := strings.Cut(strings.TrimPrefix(c.Text, DirectivePrefix), " ")
+			pos := pkg.Fset.Position(c.Pos())
+			switch name {
+			case "remove":
+				d.remove[node] = true
+				continue
+			case "pivot", "keep", "const":
+			case "if", "else", "end":
+				// Conditional sections.
+				continue
+			case "instantiate":
+				// Used by the destination packages.
+				continue
+			default:
+				return fmt.Errorf("%v: unknown directive %s", pos, c.Text)
+			}
+			for _, obj := range objs {
+				if obj == nil {
+					continue
+				}
+				switch name {
+				case "pivot":
+					if _, ok := obj.(*types.TypeName); !ok || obj.Parent() != pkg.Types.Scope() {
+						return fmt.Errorf("%v: %s is not a package level type", pos, obj.Name())
+					}
+					d.pivots[obj.Name()] = true
+				case "keep":
+					d.keep[obj] = true
+				case "const":
+					if _, ok := obj.(*types.Const); !ok {
+						return fmt.Errorf("%v: %s is not a constant", pos, obj.Name())
+					}
+					d.tunable[obj.Name()] = true
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkConsts returns an error if some of the constants to be updated are not tunable
+// while the template defines tunable ones.
+func (d *directives) checkConsts(values map[string]string) error {
+	if len(d.tunable) == 0 {
+		return nil
+	}
+	for name := range values {
+		if !d.tunable[name] {
+			return fmt.Errorf("const %s cannot be updated: missing %sconst directive", name, DirectivePrefix)
+		}
+	}
+	return nil
+}
+
+// Transform removes the declarations and specs marked for removal and
+// prevents the ones to be kept from being prefixed.
+// The directives are removed from the doc and line comments.
+// It must be applied before the declarations are prefixed.
+func (d *directives) Transform(t *Transformation) error {
+	for obj := range d.keep {
+		t.Objs[obj] = false
+	}
+	for _, pkg := range t.Pkgs {
+		for _, f := range pkg.Syntax {
+			for _, decl := range f.Decls {
+				stripDeclDirectives(t, decl)
+			}
+		}
+	}
+	if len(d.remove) == 0 {
+		return nil
+	}
+	for _, pkg := range t.Pkgs {
+		info := pkg.TypesInfo
+		for _, f := range pkg.Syntax {
+			for _, decl := range f.Decls {
+				switch decl := decl.(type) {
+				case *ast.FuncDecl:
+					if d.remove[decl] {
+						t.Logf("func %s discarded", decl.Name.Name)
+						t.removeObj(decl, info.Defs[decl.Name])
+					}
+				case *ast.GenDecl:
+					d.removeSpecs(t, info, decl)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// removeSpecs removes the specs of the declaration marked for removal, or the whole
+// declaration. Constants in a group are renamed to _ instead so that the values of iota are preserved.
+func (d *directives) removeSpecs(t *Transformation, info *types.Info, decl *ast.GenDecl) {
+	var specs []ast.Spec
+	for _, spec := range decl.Specs {
+		if !d.remove[decl] && !d.remove[spec] {
+			specs = append(specs, spec)
+			continue
+		}
+		switch spec := spec.(type) {
+		case *ast.TypeSpec:
+			t.Logf("type %s discarded", spec.Name.Name)
+			t.removeObj(nil, info.Defs[spec.Name])
+		case *ast.ValueSpec:
+			for _, id := range spec.Names {
+				t.Logf("%s %s discarded", decl.Tok, id.Name)
+				t.removeObj(nil, info.Defs[id])
+			}
+			if decl.Tok == token.CONST && !d.remove[decl] {
+				for _, id := range spec.Names {
+					t.Rename(id, "_")
+				}
+				specs = append(specs, spec)
+			}
+		}
+	}
+	switch {
+	case len(specs) == 0:
+		t.Remove(decl)
+	case len(specs) < len(decl.Specs):
+		t.Defer(func(specs []ast.Spec, lparen token.Pos) func() {
+			return func() { decl.Specs, decl.Lparen = specs, lparen }
+		}(decl.Specs, decl.Lparen))
+		decl.Specs = specs
+		if len(specs) == 1 && decl.Tok != token.CONST {
+			decl.Lparen = token.NoPos
+		}
+	}
+}
+
+// isDirective reports whether the comment is a directive.
+func isDirective(c *ast.Comment) bool {
+	return strings.HasPrefix(c.Text, DirectivePrefix)
+}
+
+// anyDirective matches all the directives.
+func anyDirective(string) bool { return true }
+
+// stripDeclDirectives removes the directives from the doc and line comments of the
+// declaration, its specs and fields.
+func stripDeclDirectives(t *Transformation, decl ast.Decl) {
+	ast.Inspect(decl, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			stripDirectives(t, &n.Doc, anyDirective)
+		case *ast.GenDecl:
+			stripDirectives(t, &n.Doc, anyDirective)
+		case *ast.TypeSpec:
+			stripDirectives(t, &n.Doc, anyDirective)
+			stripDirectives(t, &n.Comment, anyDirective)
+		case *ast.ValueSpec:
+			stripDirectives(t, &n.Doc, anyDirective)
+			stripDirectives(t, &n.Comment, anyDirective)
+		case *ast.Field:
+			stripDirectives(t, &n.Doc, anyDirective)
+			stripDirectives(t, &n.Comment, anyDirective)
+		case *ast.BlockStmt:
+			return false
+		}
+		return true
+	})
+}
+
+// stripDirectives removes the directives whose name matches from the comment group,
+// as well as the empty line separating them from the rest of the comment.
+func stripDirectives(t *Transformation, cg **ast.CommentGroup, match func(name string) bool) {
+	if *cg == nil {
+		return
+	}
+	var list []*ast.Comment
+	for _, c := range (*cg).List {
+		name, _, _ := strings.Cut(strings.TrimPrefix(c.Text, DirectivePrefix), " ")
+		if !isDirective(c) || !match(name) {
+			list = append(list, c)
+		}
+	}
+	if len(list) == len((*cg).List) {
+		return
+	}
+	if n := len(list); n > 0 && list[n-1].Text == "//" {
+		list = list[:n-1]
+	}
+	t.Defer(func(old *ast.CommentGroup) func() {
+		return func() { *cg = old }
+	}(*cg))
+	if len(list) == 0 {
+		*cg = nil
+	} else {
+		*cg = &ast.CommentGroup{List: list}
+	}
+}
@@ -1,7 +1,8 @@
 // Package packagen generate Go source code from source package(s).
 // It is largely inspired by https://github.com/golang/tools/cmd/bundle.
 //
-// Transformations can be applied to the resulting AST before outputting it.
+// Transformations can be applied to the resulting AST before outputting it, by registering
+// a Transformer in BundleOption.
 //
 // Limitations:
 // - no asm, no cgo, no build tags
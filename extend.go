@@ -3,28 +3,43 @@ package packagen
 import (
 	"fmt"
 	"go/ast"
+	"go/parser"
 	"go/token"
+	"go/types"
 	"io"
 	"log"
+	"path/filepath"
+	"strings"
 
 	"golang.org/x/tools/go/packages"
 )
 
 // ExtendOption defines the options in use when extending a type.
 type ExtendOption struct {
-	Log          *log.Logger
+	Log          *log.Logger       `json:"-"`
 	SrcPkg       string            // Package of the source type
 	Src          string            // Name of the struct type to be used as source
 	DstPkg       string            // Package of the destination type
 	Dst          string            // Name of the struct type to be extended
-	Fields       map[string]string // Map field name to the new type
+	Fields       map[string]string // Map field name, or embedded type name, to the new type
+	Types        map[string]string // Map type name, qualified by its import path if not from SrcPkg, to the new type
+	Flatten      bool              // Copy the fields of the embedded structs declared in the source package instead of embedding them
 	FieldPrefix  string            // Prefix to be used for the added fields
 	MethodPrefix string            // Prefix to be used for method names
+	Lines        bool              // Emit //line directives pointing to the source methods
+	Transformers []Transformer     `json:"-"` // Transformations applied to the source package
 }
 
 // ExtendStruct adds fields and methods from one struct to another.
 // It returns the name of the file where the destination struct is located
 // and writes the new destination content to out and its new methods (if any) to methods.
+// The element type of the fields in Fields, found by following the pointer, slice, array, channel
+// and map value types, and the types in Types are replaced wherever they occur in the field types.
+// The new types may refer to packages by their import path and the imports required by the
+// added fields are added to the destination file.
+// Embedded fields are either kept, renamed through Fields or flattened, the methods of the
+// flattened structs not being copied. Embedded fields conflicting with the fields of the
+// destination type, or promoting methods conflicting with its methods, are reported.
 func ExtendStruct(out, methods io.Writer, o ExtendOption) (string, error) {
 	dstPkg, dstType, dstStruct, err := lookupStruct(o.DstPkg, o.Dst)
 	if err != nil {
@@ -36,8 +51,14 @@ func ExtendStruct(out, methods io.Writer, o ExtendOption) (string, error) {
 	}
 
 	// Restore modified identifiers names.
-	renameID, renameDone := renamer()
-	defer renameDone()
+	trans := newTransformation([]*packages.Package{srcPkg}, func(*types.Package) string { return "" }, o.Log, false)
+	defer trans.done()
+	for _, tr := range o.Transformers {
+		if err := tr.Transform(trans); err != nil {
+			return "", err
+		}
+	}
+	renameID := trans.Rename
 
 	// Find the destination file.
 	var dstFile *ast.File
@@ -55,6 +76,11 @@ fileLoop:
 		}
 	}
 
+	m, err := newTypeMapper(o, srcPkg, dstPkg, dstFile)
+	if err != nil {
+		return "", err
+	}
+
 	// Extend the destination type.
 	for _, decl := range dstFile.Decls {
 		decl, ok := decl.(*ast.GenDecl)
@@ -67,37 +93,25 @@ fileLoop:
 				continue
 			}
 			// Extend the type with new fields.
-			for _, field := range srcStruct.Fields.List {
-				if field.Names == nil {
-					// Embedded type, ignore.
-					continue
-				}
-				name := field.Names[0].Name
-				field.Names[0].Name = o.FieldPrefix + name
-				newtype, ok := o.Fields[name]
-				if !ok {
-					// Do not change the type if none defined for this field.
-					continue
-				}
-				switch e := field.Type.(type) {
-				case *ast.Ident:
-					renameID(e, newtype)
-				case *ast.SliceExpr:
-					if id, ok := e.X.(*ast.Ident); ok {
-						renameID(id, newtype)
-					}
-				case *ast.ArrayType:
-					if id, ok := e.Elt.(*ast.Ident); ok {
-						renameID(id, newtype)
-					}
-				}
+			newFields, err := extendFields(o, trans, m, srcStruct.Fields.List, map[string]bool{})
+			if err != nil {
+				return "", err
+			}
+			list, err := mergeFields(o.Dst, dstStruct.Fields.List, newFields)
+			if err != nil {
+				return "", err
 			}
-			dstStruct.Fields.List = append(dstStruct.Fields.List, srcStruct.Fields.List...)
+			if err := checkPromoted(dstPkg, dstType, list, newFields, copiedMethods(o, trans, srcPkg)); err != nil {
+				return "", err
+			}
+			fields := dstStruct.Fields.List
+			defer func() { dstStruct.Fields.List = fields }()
+			dstStruct.Fields.List = list
 		}
 	}
 
 	// Write the new file content.
-	if err := printNode(out, dstPkg.Fset, dstFile); err != nil {
+	if err := printImports(out, dstPkg.Fset, dstFile, m.imports); err != nil {
 		return "", err
 	}
 
@@ -108,29 +122,297 @@ fileLoop:
 	for _, file := range srcPkg.Syntax {
 		for _, decl := range file.Decls {
 			fn, ok := decl.(*ast.FuncDecl)
-			if !ok || fn.Recv == nil {
+			if !ok || trans.Removed(decl) {
 				continue
 			}
 			// Got a method.
-			var id *ast.Ident
-			switch t := fn.Recv.List[0].Type.(type) {
-			case *ast.StarExpr:
-				id = t.X.(*ast.Ident)
-			case *ast.Ident:
-				id = t
-			}
+			id := recvIdent(fn)
 			if id == nil || id.Name != o.Src {
 				// Invalid receiver?? or not for the selected target type.
 				continue
 			}
 			renameID(fn.Name, o.MethodPrefix+fn.Name.Name)
-			if err := printNode(methods, srcPkg.Fset, decl); err != nil {
+			if o.Lines {
+				dir := filepath.Dir(dstPkg.Fset.File(dstFile.Pos()).Name())
+				err = printLineDecl(methods, srcPkg.Fset, file, decl, dir)
+			} else {
+				err = printNode(methods, srcPkg.Fset, decl)
+			}
+			if err != nil {
 				return "", err
 			}
 		}
 	}
 
-	return dstFile.Name.Name, nil
+	return dstPkg.Fset.File(dstFile.Pos()).Name(), nil
+}
+
+// extendFields returns the fields to be added to the destination struct, their names being
+// prefixed and their types mapped by m. Embedded fields are renamed by o.Fields keyed
+// by their type name or, with o.Flatten, replaced by the fields of their struct type if it is
+// declared in the source package. flattened records the names of the flattened structs.
+func extendFields(o ExtendOption, trans *Transformation, m *typeMapper,
+	fields []*ast.Field, flattened map[string]bool) ([]*ast.Field, error) {
+	var list []*ast.Field
+	for _, field := range fields {
+		if field.Names != nil {
+			var (
+				typ     string
+				changed bool
+				err     error
+			)
+			if newtype, ok := o.Fields[field.Names[0].Name]; ok {
+				typ, changed, err = m.mapField(field, newtype)
+			} else {
+				typ, changed, err = m.mapType(field.Type, nil, "")
+			}
+			if err != nil {
+				return nil, err
+			}
+			if changed {
+				setFieldType(trans, field, typ)
+			}
+			for _, id := range field.Names {
+				trans.Rename(id, o.FieldPrefix+id.Name)
+			}
+			list = append(list, field)
+			continue
+		}
+		// Embedded field.
+		id := embeddedIdent(field.Type)
+		if newtype, ok := o.Fields[id.Name]; ok {
+			typ, err := m.typeExpr(newtype)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := field.Type.(*ast.StarExpr); ok && typ[0] != '*' {
+				typ = "*" + typ
+			}
+			if err := setEmbeddedType(trans, field, typ); err != nil {
+				return nil, err
+			}
+			list = append(list, field)
+			continue
+		}
+		if st := embeddedStruct(m.pkg, field.Type); o.Flatten && st != nil {
+			if flattened[id.Name] {
+				return nil, fmt.Errorf("struct %s flattened more than once", id.Name)
+			}
+			flattened[id.Name] = true
+			trans.Logf("flattening embedded %s", id.Name)
+			fs, err := extendFields(o, trans, m, st.Fields.List, flattened)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, fs...)
+			continue
+		}
+		typ, changed, err := m.mapType(field.Type, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			if err := setEmbeddedType(trans, field, typ); err != nil {
+				return nil, err
+			}
+		}
+		list = append(list, field)
+	}
+	return list, nil
+}
+
+// embeddedIdent returns the identifier naming the embedded field of type expr.
+func embeddedIdent(expr ast.Expr) *ast.Ident {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e
+	case *ast.StarExpr:
+		return embeddedIdent(e.X)
+	case *ast.SelectorExpr:
+		return e.Sel
+	case *ast.IndexExpr:
+		return embeddedIdent(e.X)
+	case *ast.IndexListExpr:
+		return embeddedIdent(e.X)
+	}
+	return nil
+}
+
+// embeddedStruct returns the struct type of the embedded field of type expr
+// if it is declared in the package and not a pointer.
+func embeddedStruct(pkg *packages.Package, expr ast.Expr) *ast.StructType {
+	id, ok := expr.(*ast.Ident)
+	if !ok {
+		return nil
+	}
+	obj, ok := pkg.TypesInfo.Uses[id].(*types.TypeName)
+	if !ok || obj.Parent() != pkg.Types.Scope() {
+		return nil
+	}
+	spec := lookupType(pkg, id.Name)
+	if spec == nil {
+		return nil
+	}
+	st, _ := spec.Type.(*ast.StructType)
+	return st
+}
+
+// setEmbeddedType replaces the type of the embedded field by the type expression typ,
+// which must be a possibly qualified type name or a pointer to it.
+func setEmbeddedType(trans *Transformation, field *ast.Field, typ string) error {
+	x, err := parser.ParseExpr(typ)
+	if err != nil {
+		return fmt.Errorf("embedded %s: %v", typ, err)
+	}
+	if star, ok := x.(*ast.StarExpr); ok {
+		x = star.X
+	}
+	if _, ok := x.(*ast.StarExpr); ok || embeddedIdent(x) == nil {
+		return fmt.Errorf("invalid embedded type %s", typ)
+	}
+	setFieldType(trans, field, typ)
+	return nil
+}
+
+// setFieldType replaces the type of the field by the type expression typ
+// until the transformation is done.
+func setFieldType(trans *Transformation, field *ast.Field, typ string) {
+	old := field.Type
+	trans.Defer(func() { field.Type = old })
+	field.Type = &ast.Ident{NamePos: old.Pos(), Name: typ}
+}
+
+// fieldNames returns the names of the field, an embedded field being named after its type.
+func fieldNames(field *ast.Field) []string {
+	if field.Names == nil {
+		// The type of a renamed embedded field is an identifier holding the type expression.
+		name := strings.TrimPrefix(types.ExprString(field.Type), "*")
+		name, _, _ = strings.Cut(name, "[")
+		return []string{name[strings.LastIndexByte(name, '.')+1:]}
+	}
+	names := make([]string, len(field.Names))
+	for i, id := range field.Names {
+		names[i] = id.Name
+	}
+	return names
+}
+
+// mergeFields returns the fields of the destination struct dst with the new fields appended.
+// The fields added by a previous run are replaced so that extending is idempotent, while
+// embedded fields conflicting with the existing ones are reported.
+func mergeFields(dst string, fields, newFields []*ast.Field) ([]*ast.Field, error) {
+	added := map[string]*ast.Field{}
+	for _, field := range newFields {
+		for _, name := range fieldNames(field) {
+			if _, ok := added[name]; ok {
+				return nil, fmt.Errorf("%s: duplicate field %s", dst, name)
+			}
+			added[name] = field
+		}
+	}
+	var list []*ast.Field
+	for _, field := range fields {
+		name := fieldNames(field)[0]
+		nf, ok := added[name]
+		if !ok {
+			list = append(list, field)
+			continue
+		}
+		if field.Names != nil && nf.Names != nil || types.ExprString(field.Type) == types.ExprString(nf.Type) {
+			// Added by a previous run.
+			continue
+		}
+		if nf.Names == nil {
+			return nil, fmt.Errorf("%s: embedded %s conflicts with field %s", dst, types.ExprString(nf.Type), name)
+		}
+		return nil, fmt.Errorf("%s: field %s conflicts with embedded %s", dst, name, types.ExprString(field.Type))
+	}
+	return append(list, newFields...), nil
+}
+
+// checkPromoted returns an error if a method promoted by one of the new embedded fields
+// conflicts with a method of the destination type: declared, copied or promoted by another
+// embedded field. New embedded types not yet declared in the destination package are ignored.
+func checkPromoted(dstPkg *packages.Package, dstType *ast.TypeSpec, fields, newFields []*ast.Field, copied map[string]bool) error {
+	methods := map[string]string{} // Method name to its origin
+	for name := range copied {
+		methods[name] = "copied method"
+	}
+	if tn, ok := dstPkg.TypesInfo.Defs[dstType.Name].(*types.TypeName); ok {
+		if named, ok := tn.Type().(*types.Named); ok {
+			for i := 0; i < named.NumMethods(); i++ {
+				if name := named.Method(i).Name(); !copied[name] {
+					methods[name] = "declared method"
+				}
+			}
+		}
+	}
+	isNew := map[*ast.Field]bool{}
+	for _, field := range newFields {
+		isNew[field] = true
+	}
+	for _, field := range fields {
+		if field.Names != nil || isNew[field] {
+			continue
+		}
+		for _, name := range methodNames(dstPkg.Types, dstPkg.TypesInfo.TypeOf(field.Type)) {
+			methods[name] = "embedded " + types.ExprString(field.Type)
+		}
+	}
+	for _, field := range newFields {
+		if field.Names != nil {
+			continue
+		}
+		embedded := types.ExprString(field.Type)
+		tv, err := types.Eval(dstPkg.Fset, dstPkg.Types, token.NoPos, embedded)
+		if err != nil {
+			continue
+		}
+		for _, name := range methodNames(dstPkg.Types, tv.Type) {
+			if by, ok := methods[name]; ok {
+				return fmt.Errorf("%s: method %s promoted by embedded %s conflicts with %s",
+					dstType.Name.Name, name, embedded, by)
+			}
+			methods[name] = "embedded " + embedded
+		}
+	}
+	return nil
+}
+
+// methodNames returns the names of the methods of typ, or *typ if it is not a pointer,
+// that can be referred to from pkg.
+func methodNames(pkg *types.Package, typ types.Type) []string {
+	if typ == nil {
+		return nil
+	}
+	if _, ok := typ.(*types.Pointer); !ok && !types.IsInterface(typ) {
+		typ = types.NewPointer(typ)
+	}
+	ms := types.NewMethodSet(typ)
+	var names []string
+	for i := 0; i < ms.Len(); i++ {
+		if obj := ms.At(i).Obj(); obj.Exported() || obj.Pkg() == pkg {
+			names = append(names, obj.Name())
+		}
+	}
+	return names
+}
+
+// copiedMethods returns the new names of the methods copied from the source type.
+func copiedMethods(o ExtendOption, trans *Transformation, srcPkg *packages.Package) map[string]bool {
+	names := map[string]bool{}
+	for _, file := range srcPkg.Syntax {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || trans.Removed(decl) {
+				continue
+			}
+			if id := recvIdent(fn); id != nil && id.Name == o.Src {
+				names[o.MethodPrefix+fn.Name.Name] = true
+			}
+		}
+	}
+	return names
 }
 
 func lookupStruct(pname, tname string) (p *packages.Package, t *ast.TypeSpec, s *ast.StructType, err error) {
@@ -20,8 +20,27 @@ func TestExtendStruct(t *testing.T) {
 			FieldPrefix:  "field_",
 			MethodPrefix: "method_",
 		},
+		{
+			SrcPkg:       "./testdata/extend/src",
+			Src:          "Record",
+			DstPkg:       "./testdata/extend/dst",
+			Dst:          "ExRecord",
+			Fields:       map[string]string{"Meta": "Info", "id": "int64"},
+			FieldPrefix:  "field_",
+			MethodPrefix: "method_",
+			Flatten:      true,
+		},
+		{
+			SrcPkg:      "./testdata/extend/src",
+			Src:         "Typed",
+			DstPkg:      "./testdata/extend/dst",
+			Dst:         "ExTyped",
+			Fields:      map[string]string{"m": "int64", "p": "uint"},
+			Types:       map[string]string{"Entry": "time.Duration", "bytes.Buffer": "strings.Builder"},
+			FieldPrefix: "field_",
+		},
 	} {
-		t.Run(tc.SrcPkg, func(t *testing.T) {
+		t.Run(tc.Dst, func(t *testing.T) {
 			c := qt.New(t)
 
 			var buf, methods bytes.Buffer
@@ -49,3 +68,34 @@ func TestExtendStruct(t *testing.T) {
 		})
 	}
 }
+
+func TestExtendStructErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src, dst string
+		fields   map[string]string
+		err      string
+	}{
+		{"Record", "ExTagged", map[string]string{"Meta": "Info"},
+			"ExTagged: method Tags promoted by embedded \\*Info conflicts with declared method"},
+		{"Record", "ExInfo", map[string]string{"Meta": "Info"},
+			"ExInfo: embedded \\*Info conflicts with field Info"},
+		{"Record", "ExInfo", map[string]string{"Meta": "[]Info"},
+			"invalid embedded type \\*\\[\\]Info"},
+		{"Typed", "ExTyped", map[string]string{"f": "int64"},
+			"field f: no element type to be mapped in func\\(Entry\\) error"},
+	} {
+		t.Run(tc.dst, func(t *testing.T) {
+			c := qt.New(t)
+
+			var buf, methods bytes.Buffer
+			_, err := ExtendStruct(&buf, &methods, ExtendOption{
+				SrcPkg: "./testdata/extend/src",
+				Src:    tc.src,
+				DstPkg: "./testdata/extend/dst",
+				Dst:    tc.dst,
+				Fields: tc.fields,
+			})
+			c.Assert(err, qt.ErrorMatches, tc.err)
+		})
+	}
+}
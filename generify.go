@@ -0,0 +1,601 @@
+package packagen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/imports"
+)
+
+// GenerifyOption defines the options for the Generify processor.
+type GenerifyOption struct {
+	Log    *log.Logger
+	Pkg    string            // Package to be processed
+	NewPkg string            // Name of the resulting package (default=processed package name)
+	Pivots map[string]string // Map the names of the pivot types to their type parameter name
+}
+
+// Generify converts the package identified by o.Pkg, written with pivot types, into generic code
+// and writes it to out.
+//
+// Each pivot type is replaced by a type parameter whose constraint is an interface named
+// after the pivot and inferred from its methods and the operators applied to its values.
+// The types and functions using a pivot, directly or not, become generic declarations
+// and their references are explicitly instantiated.
+func Generify(out io.Writer, o GenerifyOption) error {
+	if o.Log != nil {
+		o.Log.Printf("Options: %#v\n", o)
+		o.Log.Printf("Loading packages with %v\n", o.Pkg)
+	}
+	pkgs, err := loadPkg(o.Pkg)
+	if err != nil {
+		return err
+	}
+	if packages.PrintErrors(pkgs) > 0 {
+		return fmt.Errorf("too many errors while loading package %s", o.Pkg)
+	}
+	if len(pkgs) != 1 {
+		return fmt.Errorf("%s: found %d packages instead of one", o.Pkg, len(pkgs))
+	}
+	pkg := pkgs[0]
+	g, err := newGenerifier(pkg, o.Pivots)
+	if err != nil {
+		return err
+	}
+
+	renameID, renameDone := renamer()
+	defer renameDone()
+	g.rename(renameID)
+
+	var buf bytes.Buffer
+	newName := o.NewPkg
+	if newName == "" {
+		newName = pkg.Name
+	}
+	_, err = fmt.Fprintf(&buf, "package %s\n\n", newName)
+	if err != nil {
+		return err
+	}
+	if err := g.print(&buf, o.Log); err != nil {
+		return err
+	}
+
+	// Resolve imports and format the resulting code.
+	code, err := imports.Process("", buf.Bytes(), nil)
+	if err != nil {
+		// Output without imports.
+		_, _ = io.Copy(out, &buf)
+		return err
+	}
+	_, err = io.Copy(out, bytes.NewReader(code))
+	return err
+}
+
+// pivot is a type to be replaced by a type parameter.
+type pivot struct {
+	obj        *types.TypeName
+	param      string        // Type parameter name
+	spec       *ast.TypeSpec // Declaration of the pivot type
+	params     []*pivot      // Pivots used by the constraint methods
+	comparable bool          // Values are compared
+	ops        []types.BasicInfo
+}
+
+// constraint returns the constraint reference for the type parameter.
+func (p *pivot) constraint() string {
+	if len(p.params) == 0 {
+		return p.obj.Name()
+	}
+	return p.obj.Name() + instance(p.params)
+}
+
+// typeSet returns the types allowed by the operators applied to the pivot values.
+// It returns nil if the types are not restricted.
+func (p *pivot) typeSet() []string {
+	if len(p.ops) == 0 {
+		return nil
+	}
+	var set []string
+next:
+	for _, kind := range constraintKinds {
+		t := types.Typ[kind]
+		for _, op := range p.ops {
+			if t.Info()&op == 0 {
+				continue next
+			}
+		}
+		set = append(set, "~"+t.Name())
+	}
+	return set
+}
+
+// constraintKinds lists the types that can be part of an inferred constraint type set.
+var constraintKinds = []types.BasicKind{
+	types.Bool,
+	types.Int, types.Int8, types.Int16, types.Int32, types.Int64,
+	types.Uint, types.Uint8, types.Uint16, types.Uint32, types.Uint64, types.Uintptr,
+	types.Float32, types.Float64,
+	types.Complex64, types.Complex128,
+	types.String,
+}
+
+// opInfo returns the types class supporting the operator, or 0 if it is not restricted.
+func opInfo(op token.Token) types.BasicInfo {
+	switch op {
+	case token.LSS, token.LEQ, token.GTR, token.GEQ:
+		return types.IsOrdered
+	case token.ADD:
+		return types.IsNumeric | types.IsString
+	case token.SUB, token.MUL, token.QUO:
+		return types.IsNumeric
+	case token.REM, token.AND, token.OR, token.XOR, token.AND_NOT, token.SHL, token.SHR:
+		return types.IsInteger
+	case token.NOT, token.LAND, token.LOR:
+		return types.IsBoolean
+	}
+	return 0
+}
+
+// constInfo returns the types class able to represent the constant.
+func constInfo(v constant.Value) types.BasicInfo {
+	switch v.Kind() {
+	case constant.Bool:
+		return types.IsBoolean
+	case constant.String:
+		return types.IsString
+	}
+	return types.IsNumeric
+}
+
+// instance returns the type arguments list for the pivots.
+func instance(pivots []*pivot) string {
+	s := make([]string, len(pivots))
+	for i, p := range pivots {
+		s[i] = p.param
+	}
+	return "[" + strings.Join(s, ", ") + "]"
+}
+
+// generifier holds the state of a package being converted into generic code.
+type generifier struct {
+	pkg     *packages.Package
+	pivots  map[types.Object]*pivot
+	skip    map[ast.Node]bool               // Pivots declarations and methods
+	generic map[types.Object][]*pivot       // Generic declarations with their type parameters
+	decls   map[types.Object]ast.Node       // Package level declarations
+	uses    map[types.Object][]types.Object // Pivots and declarations used by a declaration
+}
+
+func newGenerifier(pkg *packages.Package, pivotParams map[string]string) (*generifier, error) {
+	if len(pivotParams) == 0 {
+		return nil, fmt.Errorf("no pivot type")
+	}
+	g := &generifier{
+		pkg:     pkg,
+		pivots:  map[types.Object]*pivot{},
+		skip:    map[ast.Node]bool{},
+		generic: map[types.Object][]*pivot{},
+		decls:   map[types.Object]ast.Node{},
+		uses:    map[types.Object][]types.Object{},
+	}
+	for name, param := range pivotParams {
+		obj, ok := pkg.Types.Scope().Lookup(name).(*types.TypeName)
+		if !ok || obj.IsAlias() {
+			return nil, fmt.Errorf("pivot type %s not found", name)
+		}
+		g.pivots[obj] = &pivot{obj: obj, param: param}
+	}
+	g.collect()
+	if err := g.infer(); err != nil {
+		return nil, err
+	}
+	if err := g.resolve(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// sorted returns the pivots sorted by name.
+func sorted(pivots map[*pivot]bool) []*pivot {
+	s := make([]*pivot, 0, len(pivots))
+	for p := range pivots {
+		s = append(s, p)
+	}
+	sort.Slice(s, func(i, j int) bool { return s[i].obj.Name() < s[j].obj.Name() })
+	return s
+}
+
+// collect records the package declarations and the pivots ones.
+func (g *generifier) collect() {
+	info := g.pkg.TypesInfo
+	for _, f := range g.pkg.Syntax {
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				obj := info.Defs[decl.Name]
+				if obj == nil {
+					continue
+				}
+				if g.pivots[recvTypeName(obj)] != nil {
+					g.skip[decl] = true
+					continue
+				}
+				g.decls[obj] = decl
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						obj := info.Defs[spec.Name]
+						if p := g.pivots[obj]; p != nil {
+							p.spec = spec
+							g.skip[spec] = true
+							continue
+						}
+						g.decls[obj] = spec
+					case *ast.ValueSpec:
+						for _, id := range spec.Names {
+							if obj := info.Defs[id]; obj != nil {
+								g.decls[obj] = spec
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	// Objects used by the declarations.
+	for obj, node := range g.decls {
+		seen := map[types.Object]bool{}
+		ast.Inspect(node, func(n ast.Node) bool {
+			id, ok := n.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			used := info.Uses[id]
+			if used == nil || used == obj || seen[used] {
+				return true
+			}
+			if g.pivots[used] != nil || g.decls[used] != nil {
+				seen[used] = true
+				g.uses[obj] = append(g.uses[obj], used)
+			}
+			return true
+		})
+		// The receiver type of a method is as generic as the method.
+		if tn := recvTypeName(obj); tn != nil && g.decls[tn] != nil {
+			g.uses[tn] = append(g.uses[tn], obj)
+		}
+	}
+}
+
+// infer builds the constraints of the pivots from their methods and
+// the operators applied to their values.
+func (g *generifier) infer() error {
+	info := g.pkg.TypesInfo
+	pivotOf := func(e ast.Expr) *pivot {
+		if named, ok := info.TypeOf(e).(*types.Named); ok {
+			return g.pivots[named.Obj()]
+		}
+		return nil
+	}
+	for _, p := range g.pivots {
+		if p.spec == nil {
+			return fmt.Errorf("pivot type %s: declaration not found", p.obj.Name())
+		}
+	}
+	var err error
+	for _, node := range g.decls {
+		ast.Inspect(node, func(n ast.Node) bool {
+			if e, ok := n.(ast.Expr); ok {
+				if tv := info.Types[e]; tv.Value != nil {
+					// Constant of a pivot type.
+					if p := pivotOf(e); p != nil {
+						p.ops = append(p.ops, constInfo(tv.Value))
+					}
+					return false
+				}
+			}
+			switch n := n.(type) {
+			case *ast.BinaryExpr:
+				p := pivotOf(n.X)
+				if p == nil {
+					p = pivotOf(n.Y)
+				}
+				if p == nil {
+					break
+				}
+				switch n.Op {
+				case token.EQL, token.NEQ:
+					if info.Types[n.X].IsNil() || info.Types[n.Y].IsNil() {
+						err = fmt.Errorf("pivot type %s: values cannot be compared to nil", p.obj.Name())
+					}
+					p.comparable = true
+				default:
+					if op := opInfo(n.Op); op != 0 {
+						p.ops = append(p.ops, op)
+					}
+				}
+			case *ast.UnaryExpr:
+				if p := pivotOf(n.X); p != nil {
+					switch n.Op {
+					case token.SUB, token.ADD:
+						p.ops = append(p.ops, types.IsNumeric)
+					case token.XOR:
+						p.ops = append(p.ops, types.IsInteger)
+					case token.NOT:
+						p.ops = append(p.ops, types.IsBoolean)
+					}
+				}
+			case *ast.IncDecStmt:
+				if p := pivotOf(n.X); p != nil {
+					p.ops = append(p.ops, types.IsNumeric)
+				}
+			case *ast.AssignStmt:
+				if n.Tok >= token.ADD_ASSIGN && n.Tok <= token.AND_NOT_ASSIGN {
+					if p := pivotOf(n.Lhs[0]); p != nil {
+						p.ops = append(p.ops, opInfo(n.Tok-token.ADD_ASSIGN+token.ADD))
+					}
+				}
+			case *ast.MapType:
+				if p := pivotOf(n.Key); p != nil {
+					p.comparable = true
+				}
+			case *ast.SwitchStmt:
+				if n.Tag != nil {
+					if p := pivotOf(n.Tag); p != nil {
+						p.comparable = true
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Type parameters of the constraints.
+	for _, p := range g.pivots {
+		params := map[*pivot]bool{}
+		ast.Inspect(p.spec, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				if q := g.pivots[info.Uses[id]]; q != nil {
+					params[q] = true
+				}
+			}
+			return true
+		})
+		for _, fn := range g.pivotMethods(p) {
+			sig := info.Defs[fn.Name].Type().(*types.Signature)
+			if _, ok := sig.Recv().Type().(*types.Pointer); ok {
+				return fmt.Errorf("pivot type %s: method %s has a pointer receiver", p.obj.Name(), fn.Name.Name)
+			}
+			ast.Inspect(fn.Type, func(n ast.Node) bool {
+				if id, ok := n.(*ast.Ident); ok {
+					if q := g.pivots[info.Uses[id]]; q != nil {
+						params[q] = true
+					}
+				}
+				return true
+			})
+		}
+		p.params = sorted(params)
+		if len(p.ops) > 0 && len(p.typeSet()) == 0 {
+			return fmt.Errorf("pivot type %s: no type supports the operators applied to its values", p.obj.Name())
+		}
+	}
+	return nil
+}
+
+// pivotMethods returns the methods declared on the pivot.
+func (g *generifier) pivotMethods(p *pivot) []*ast.FuncDecl {
+	var methods []*ast.FuncDecl
+	for _, f := range g.pkg.Syntax {
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && g.skip[fn] {
+				if recvTypeName(g.pkg.TypesInfo.Defs[fn.Name]) == p.obj {
+					methods = append(methods, fn)
+				}
+			}
+		}
+	}
+	return methods
+}
+
+// resolve sets the type parameters of the declarations using the pivots, directly or not.
+func (g *generifier) resolve() error {
+	params := map[types.Object]map[*pivot]bool{}
+	for changed := true; changed; {
+		changed = false
+		for obj, uses := range g.uses {
+			m := params[obj]
+			if m == nil {
+				m = map[*pivot]bool{}
+				params[obj] = m
+			}
+			add := func(p *pivot) {
+				if !m[p] {
+					m[p] = true
+					changed = true
+				}
+			}
+			for _, used := range uses {
+				if p := g.pivots[used]; p != nil {
+					add(p)
+					continue
+				}
+				for p := range params[used] {
+					add(p)
+				}
+			}
+		}
+	}
+	for obj, m := range params {
+		if len(m) == 0 {
+			continue
+		}
+		switch obj := obj.(type) {
+		case *types.Var, *types.Const:
+			return fmt.Errorf("%s uses a pivot type and cannot be made generic", obj.Name())
+		}
+		g.generic[obj] = sorted(m)
+	}
+	return nil
+}
+
+// rename replaces the pivots by their type parameter and adds the type parameters
+// to the generic declarations and their references.
+func (g *generifier) rename(renameID func(*ast.Ident, string)) {
+	info := g.pkg.TypesInfo
+	for id, obj := range info.Uses {
+		if p := g.pivots[obj]; p != nil {
+			renameID(id, p.param)
+			continue
+		}
+		if params, ok := g.generic[obj]; ok {
+			if recvTypeName(obj) != nil {
+				// Methods are instantiated with their receiver.
+				continue
+			}
+			renameID(id, obj.Name()+instance(params))
+		}
+	}
+	for obj, params := range g.generic {
+		if recvTypeName(obj) != nil {
+			continue
+		}
+		var id *ast.Ident
+		switch node := g.decls[obj].(type) {
+		case *ast.FuncDecl:
+			id = node.Name
+		case *ast.TypeSpec:
+			id = node.Name
+		}
+		decl := make([]string, len(params))
+		for i, p := range params {
+			decl[i] = p.param + " " + p.constraint()
+		}
+		renameID(id, obj.Name()+"["+strings.Join(decl, ", ")+"]")
+	}
+}
+
+// print writes the declarations of the package, the pivots being replaced
+// by their constraint.
+func (g *generifier) print(buf *bytes.Buffer, logger *log.Logger) error {
+	fset := g.pkg.Fset
+	for _, f := range g.pkg.Syntax {
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if g.skip[decl] {
+					if logger != nil {
+						logger.Printf("method %s moved to the constraint", decl.Name.Name)
+					}
+					continue
+				}
+			case *ast.GenDecl:
+				if decl.Tok == token.IMPORT {
+					continue
+				}
+				if decl.Tok != token.TYPE {
+					break
+				}
+				var specs []ast.Spec
+				for _, spec := range decl.Specs {
+					if !g.skip[spec] {
+						specs = append(specs, spec)
+						continue
+					}
+					p := g.pivots[g.pkg.TypesInfo.Defs[spec.(*ast.TypeSpec).Name]]
+					if err := g.printConstraint(buf, p); err != nil {
+						return err
+					}
+				}
+				if len(specs) == 0 {
+					continue
+				}
+				if len(specs) < len(decl.Specs) {
+					defer func(decl *ast.GenDecl, specs []ast.Spec, lparen token.Pos) {
+						decl.Specs, decl.Lparen = specs, lparen
+					}(decl, decl.Specs, decl.Lparen)
+					decl.Specs = specs
+					if len(specs) == 1 {
+						decl.Lparen = token.NoPos
+					}
+				}
+			}
+			if err := printNode(buf, fset, decl); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// printConstraint writes the constraint interface of the pivot.
+func (g *generifier) printConstraint(buf *bytes.Buffer, p *pivot) error {
+	fset := g.pkg.Fset
+	name := p.obj.Name()
+	if len(p.params) > 0 {
+		decl := make([]string, len(p.params))
+		for i, q := range p.params {
+			decl[i] = q.param + " any"
+		}
+		name += "[" + strings.Join(decl, ", ") + "]"
+	}
+	fmt.Fprintf(buf, "// %s is the constraint of the %s type parameter.\n", p.obj.Name(), p.param)
+	fmt.Fprintf(buf, "type %s interface {\n", name)
+	if set := p.typeSet(); set != nil {
+		fmt.Fprintf(buf, "%s\n", strings.Join(set, " | "))
+	} else if p.comparable {
+		fmt.Fprintf(buf, "comparable\n")
+	}
+	if iface, ok := p.spec.Type.(*ast.InterfaceType); ok {
+		for _, field := range iface.Methods.List {
+			if len(field.Names) > 0 {
+				buf.WriteString(field.Names[0].Name)
+				if err := printSignature(buf, fset, field.Type.(*ast.FuncType)); err != nil {
+					return err
+				}
+				continue
+			}
+			// Embedded interface.
+			if err := printNode(buf, fset, field.Type); err != nil {
+				return err
+			}
+		}
+	}
+	for _, fn := range g.pivotMethods(p) {
+		if fn.Doc != nil {
+			for _, c := range fn.Doc.List {
+				fmt.Fprintf(buf, "%s\n", c.Text)
+			}
+		}
+		buf.WriteString(fn.Name.Name)
+		if err := printSignature(buf, fset, fn.Type); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(buf, "}\n\n")
+	return err
+}
+
+// printSignature writes the function type without the func keyword.
+func printSignature(buf *bytes.Buffer, fset *token.FileSet, fn *ast.FuncType) error {
+	var b bytes.Buffer
+	if err := printNode(&b, fset, fn); err != nil {
+		return err
+	}
+	buf.WriteString(strings.TrimPrefix(b.String(), "func"))
+	return nil
+}
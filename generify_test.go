@@ -0,0 +1,38 @@
+package packagen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestGenerify(t *testing.T) {
+	for _, tc := range []GenerifyOption{
+		{
+			Pkg:    "./testdata/generify",
+			Pivots: map[string]string{"Item": "T", "Key": "K"},
+		},
+	} {
+		t.Run(tc.Pkg, func(t *testing.T) {
+			c := qt.New(t)
+
+			var buf bytes.Buffer
+			err := Generify(&buf, tc)
+			c.Assert(err, qt.IsNil)
+
+			fname := filepath.Join("testdata", "generify.golden")
+			if *update {
+				t.Log("update golden file")
+				if err := ioutil.WriteFile(fname, buf.Bytes(), 0644); err != nil {
+					t.Fatalf("failed to update golden file: %s", err)
+				}
+			}
+			result, err := ioutil.ReadFile(fname)
+			c.Assert(err, qt.IsNil)
+			c.Assert(buf.String(), qt.Equals, string(result))
+		})
+	}
+}
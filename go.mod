@@ -1,10 +1,18 @@
 module github.com/pierrec/packagen
 
-go 1.12
+go 1.26.0
 
 require (
 	github.com/frankban/quicktest v1.4.0
 	github.com/google/renameio v0.1.0
 	github.com/pierrec/cmdflag v0.0.1
-	golang.org/x/tools v0.0.0-20190515174815-473d3dc1d7eb
+	golang.org/x/tools v0.50.0
+)
+
+require (
+	github.com/google/go-cmp v0.6.0 // indirect
+	github.com/kr/pretty v0.1.0 // indirect
+	github.com/kr/text v0.1.0 // indirect
+	golang.org/x/mod v0.41.0 // indirect
+	golang.org/x/sync v0.23.0 // indirect
 )
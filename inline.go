@@ -0,0 +1,57 @@
+package packagen
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// inlinedPkgs returns the packages imported by pkgs, directly or through other inlined packages,
+// whose import path matches one of the patterns.
+func inlinedPkgs(pkgs []*packages.Package, patterns []string) []*packages.Package {
+	seen := map[*packages.Package]bool{}
+	for _, pkg := range pkgs {
+		seen[pkg] = true
+	}
+	var deps []*packages.Package
+	var visit func(*packages.Package)
+	visit = func(pkg *packages.Package) {
+		paths := make([]string, 0, len(pkg.Imports))
+		for path := range pkg.Imports {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			imp := pkg.Imports[path]
+			if seen[imp] || !matchPatterns(patterns, imp.PkgPath) {
+				continue
+			}
+			seen[imp] = true
+			deps = append(deps, imp)
+			visit(imp)
+		}
+	}
+	for _, pkg := range pkgs {
+		visit(pkg)
+	}
+	return deps
+}
+
+// matchPatterns reports whether the import path matches one of the patterns,
+// where ... is a wildcard as in the go tool patterns.
+func matchPatterns(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		re := regexp.QuoteMeta(pattern)
+		re = strings.Replace(re, `\.\.\.`, `.*`, -1)
+		// foo/... also matches foo.
+		if strings.HasSuffix(re, `/.*`) {
+			re = re[:len(re)-len(`/.*`)] + `(/.*)?`
+		}
+		if ok, _ := regexp.MatchString("^"+re+"$", path); ok {
+			return true
+		}
+	}
+	return false
+}
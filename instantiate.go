@@ -0,0 +1,288 @@
+package packagen
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+)
+
+// constraintTypes returns the names of the interfaces of the packages that can only be used
+// as type constraints, i.e. the ones with a type set.
+func constraintTypes(pkgs []*packages.Package) []string {
+	var names []string
+	for _, pkg := range pkgs {
+		scope := pkg.Types.Scope()
+		for _, name := range scope.Names() {
+			if isConstraint(scope.Lookup(name)) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// isConstraint reports whether obj is an interface that can only be used as a type constraint.
+func isConstraint(obj types.Object) bool {
+	if _, ok := obj.(*types.TypeName); !ok {
+		return false
+	}
+	iface, ok := obj.Type().Underlying().(*types.Interface)
+	return ok && !iface.IsMethodSet()
+}
+
+// typeParams returns the type parameters of the generic type or function, or nil.
+func typeParams(obj types.Object) *types.TypeParamList {
+	switch t := obj.Type().(type) {
+	case *types.Named:
+		if _, ok := obj.(*types.TypeName); ok && t.TypeParams().Len() > 0 {
+			return t.TypeParams()
+		}
+	case *types.Signature:
+		if t.TypeParams().Len() > 0 {
+			return t.TypeParams()
+		}
+	}
+	return nil
+}
+
+// isTypeParam reports whether obj is a type parameter.
+func isTypeParam(obj types.Object) bool {
+	if tn, ok := obj.(*types.TypeName); ok {
+		_, ok := tn.Type().(*types.TypeParam)
+		return ok
+	}
+	return false
+}
+
+// instantiate replaces the type parameters of the generic declarations of the packages by
+// the type arguments, keyed by type parameter name, and removes the type parameters lists and
+// the instantiations of the generic declarations.
+// The type arguments refer to packages by the name given in imports, keyed by import path.
+// Each generic declaration must only be instantiated with the given type arguments, all
+// of them being used and all the type parameters, including the ones of the method receivers,
+// having one.
+// It returns the handler to be called to restore the packages.
+func instantiate(pkgs []*packages.Package, args, imports map[string]string,
+	renameID func(*ast.Ident, string)) (done func(), err error) {
+	var restore []func()
+	undo := func() {
+		for i := len(restore) - 1; i >= 0; i-- {
+			restore[i]()
+		}
+	}
+	if len(args) == 0 {
+		return undo, nil
+	}
+	defer func() {
+		if err != nil {
+			undo()
+		}
+	}()
+
+	used := map[string]bool{}
+	for _, pkg := range pkgs {
+		info := pkg.TypesInfo
+		// Type arguments in the package scope.
+		targs := map[string]types.Type{}
+		for name, s := range args {
+			t, err := evalType(pkg, s, imports)
+			if err != nil {
+				return nil, fmt.Errorf("type argument %s: %v", name, err)
+			}
+			targs[name] = t
+		}
+
+		// Generic declarations and their type arguments.
+		generic := map[types.Object][]types.Type{}
+		scope := pkg.Types.Scope()
+		for _, name := range scope.Names() {
+			obj := scope.Lookup(name)
+			tparams := typeParams(obj)
+			if tparams == nil || isConstraint(obj) {
+				continue
+			}
+			list := make([]types.Type, tparams.Len())
+			for i := range list {
+				tname := tparams.At(i).Obj().Name()
+				t, ok := targs[tname]
+				if !ok {
+					return nil, fmt.Errorf("%s: missing type argument for %s", name, tname)
+				}
+				list[i] = t
+			}
+			if _, err := types.Instantiate(nil, obj.Type(), list, true); err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			generic[obj] = list
+		}
+
+		// The type parameters of the method receivers may be named differently.
+		for id, obj := range info.Defs {
+			if obj == nil || !isTypeParam(obj) {
+				continue
+			}
+			if _, ok := args[obj.Name()]; !ok {
+				return nil, fmt.Errorf("%v: missing type argument for %s", pkg.Fset.Position(id.Pos()), obj.Name())
+			}
+			used[obj.Name()] = true
+		}
+
+		// The generic declarations can only be instantiated once.
+		for id, inst := range info.Instances {
+			obj := info.Uses[id]
+			list, ok := generic[obj]
+			if !ok {
+				continue
+			}
+			for i := 0; i < inst.TypeArgs.Len(); i++ {
+				t := inst.TypeArgs.At(i)
+				if tp, ok := t.(*types.TypeParam); ok {
+					t = targs[tp.Obj().Name()]
+				}
+				if t == nil || !types.Identical(t, list[i]) {
+					return nil, fmt.Errorf("%v: %s instantiated with %v instead of %v",
+						pkg.Fset.Position(id.Pos()), obj.Name(), inst.TypeArgs.At(i), list[i])
+				}
+			}
+		}
+
+		// Replace the type parameters.
+		convs := conversions(pkg)
+		rename := func(id *ast.Ident, obj types.Object) {
+			if !isTypeParam(obj) {
+				return
+			}
+			newname := args[obj.Name()]
+			if convs[id] && needParens(newname) {
+				newname = "(" + newname + ")"
+			}
+			renameID(id, newname)
+		}
+		for id, obj := range info.Defs {
+			rename(id, obj)
+		}
+		for id, obj := range info.Uses {
+			rename(id, obj)
+		}
+
+		// Remove the type parameters lists and the instantiations.
+		for _, f := range pkg.Syntax {
+			instances := map[ast.Node]ast.Node{}
+			astutil.Apply(f, func(c *astutil.Cursor) bool {
+				switch n := c.Node().(type) {
+				case *ast.TypeSpec:
+					if n.TypeParams != nil {
+						restore = append(restore, func(n *ast.TypeSpec, l *ast.FieldList) func() {
+							return func() { n.TypeParams = l }
+						}(n, n.TypeParams))
+						n.TypeParams = nil
+					}
+				case *ast.FuncType:
+					if n.TypeParams != nil {
+						restore = append(restore, func(n *ast.FuncType, l *ast.FieldList) func() {
+							return func() { n.TypeParams = l }
+						}(n, n.TypeParams))
+						n.TypeParams = nil
+					}
+				case *ast.IndexExpr:
+					if x := genericExpr(info, n.X, generic); x != nil {
+						instances[x] = n
+						c.Replace(x)
+					}
+				case *ast.IndexListExpr:
+					if x := genericExpr(info, n.X, generic); x != nil {
+						instances[x] = n
+						c.Replace(x)
+					}
+				}
+				return true
+			}, nil)
+			if len(instances) == 0 {
+				continue
+			}
+			restore = append(restore, func(f *ast.File) func() {
+				return func() {
+					astutil.Apply(f, func(c *astutil.Cursor) bool {
+						if n, ok := instances[c.Node()]; ok {
+							c.Replace(n)
+							return false
+						}
+						return true
+					}, nil)
+				}
+			}(f))
+		}
+	}
+	var unused []string
+	for name := range args {
+		if !used[name] {
+			unused = append(unused, name)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		return nil, fmt.Errorf("type parameters not found: %v", unused)
+	}
+	return undo, nil
+}
+
+// evalType evaluates the type expression s in the scope of the package, its qualified
+// identifiers referring to the given imports, keyed by import path.
+func evalType(pkg *packages.Package, s string, imports map[string]string) (types.Type, error) {
+	expr, err := parser.ParseExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	// Scope made of the package declarations and the imports.
+	tmp := types.NewPackage(pkg.PkgPath, pkg.Name)
+	scope := pkg.Types.Scope()
+	for _, name := range scope.Names() {
+		tmp.Scope().Insert(scope.Lookup(name))
+	}
+	for path, name := range imports {
+		imp := pkg.Imports[path]
+		if imp == nil {
+			pkgs, err := loadPkg(path)
+			if err != nil {
+				return nil, err
+			}
+			if len(pkgs) != 1 {
+				return nil, fmt.Errorf("package %s not found", path)
+			}
+			imp = pkgs[0]
+		}
+		tmp.Scope().Insert(types.NewPkgName(token.NoPos, tmp, name, imp.Types))
+	}
+	info := &types.Info{Types: map[ast.Expr]types.TypeAndValue{}}
+	if err := types.CheckExpr(pkg.Fset, tmp, token.NoPos, expr, info); err != nil {
+		if terr, ok := err.(types.Error); ok {
+			// The position is not relevant to the expression.
+			return nil, errors.New(terr.Msg)
+		}
+		return nil, err
+	}
+	tv := info.Types[expr]
+	if !tv.IsType() {
+		return nil, fmt.Errorf("%s is not a type", s)
+	}
+	return tv.Type, nil
+}
+
+// genericExpr returns the expression x if it refers to one of the generic declarations, or nil.
+func genericExpr(info *types.Info, x ast.Expr, generic map[types.Object][]types.Type) ast.Expr {
+	id, ok := x.(*ast.Ident)
+	if !ok {
+		return nil
+	}
+	if _, ok := generic[info.Uses[id]]; !ok {
+		return nil
+	}
+	return x
+}
@@ -0,0 +1,2 @@
+// Straight copy from https://github.com/golang/go/tree/master/src/internal/diff.
+package diff
@@ -8,94 +8,109 @@ import (
 	"go/token"
 	"go/types"
 	"io"
+	"path/filepath"
 
+	"golang.org/x/tools/go/ast/astutil"
 	"golang.org/x/tools/go/packages"
 )
 
-// renamePkg renames all used type names with the ones in names for the given package.
-func renamePkg(pkg *packages.Package, names map[string]string, ignore map[string]bool,
-	objsToUpdate map[types.Object]bool, renameID func(*ast.Ident, string)) {
-	info := pkg.TypesInfo
-	for id, obj := range info.Defs {
-		if ignore[id.Name] {
-			objsToUpdate[obj] = false
-		}
-		if newname, ok := names[id.Name]; ok {
-			objsToUpdate[obj] = false
-			if !ignore[id.Name] {
-				// Exclude types to be removed.
-				renameID(id, newname)
+// conversions returns the identifiers used in conversions (or calls), which may need to be
+// parenthesized when renamed to a type expression.
+func conversions(pkg *packages.Package) map[*ast.Ident]bool {
+	convs := map[*ast.Ident]bool{}
+	for _, f := range pkg.Syntax {
+		ast.Inspect(f, func(n ast.Node) bool {
+			if call, ok := n.(*ast.CallExpr); ok {
+				if id, ok := call.Fun.(*ast.Ident); ok {
+					convs[id] = true
+				}
 			}
-		}
-	}
-	for id, obj := range info.Uses {
-		if ignore[id.Name] {
-			objsToUpdate[obj] = false
-		}
-		if newname, ok := names[id.Name]; ok {
-			objsToUpdate[obj] = false
-			renameID(id, newname)
-		}
+			return true
+		})
 	}
+	return convs
 }
 
-// prefixPkg prefixes all global identifiers (types, variables, functions).
-func prefixPkg(pkg *packages.Package, prefix string,
+// prefixPkgs prefixes all global identifiers (types, variables, functions) of the packages
+// with the prefix of their package, including their references from the other packages.
+func prefixPkgs(pkgs []*packages.Package, prefix func(*types.Package) string,
 	objsToUpdate map[types.Object]bool, renameID func(*ast.Ident, string)) {
-	info := pkg.TypesInfo
-	// Contains all the objects to be renamed.
-	// Copied from https://github.com/golang/tools/blob/master/cmd/bundle/main.go:210
-	var rename func(from types.Object)
-	rename = func(from types.Object) {
-		if _, ok := objsToUpdate[from]; ok {
-			// Ignore objects that are already updated.
-			return
-		}
-		objsToUpdate[from] = true
+	for _, pkg := range pkgs {
+		info := pkg.TypesInfo
+		// Contains all the objects to be renamed.
+		// Copied from https://github.com/golang/tools/blob/master/cmd/bundle/main.go:210
+		var rename func(from types.Object)
+		rename = func(from types.Object) {
+			if _, ok := objsToUpdate[from]; ok {
+				// Ignore objects that are already updated.
+				return
+			}
+			objsToUpdate[from] = true
 
-		// Renaming a type that is used as an embedded field
-		// requires renaming the field too. e.g.
-		// 	type T int // if we rename this to U..
-		// 	var s struct {T}
-		// 	print(s.T) // ...this must change too
-		if _, ok := from.(*types.TypeName); !ok {
-			return
-		}
-		for id, obj := range info.Uses {
-			if obj == from {
-				if field := info.Defs[id]; field != nil {
-					rename(field)
+			// Renaming a type that is used as an embedded field
+			// requires renaming the field too. e.g.
+			// 	type T int // if we rename this to U..
+			// 	var s struct {T}
+			// 	print(s.T) // ...this must change too
+			if _, ok := from.(*types.TypeName); !ok {
+				return
+			}
+			for id, obj := range info.Uses {
+				if obj == from {
+					if field := info.Defs[id]; field != nil {
+						rename(field)
+					}
 				}
 			}
 		}
-	}
 
-	// Populate the map with the objects to be prefixed.
-	// Only the ones in the top package scope need to be prefixed.
-	scope := pkg.Types.Scope()
-	for _, name := range scope.Names() {
-		rename(scope.Lookup(name))
+		// Populate the map with the objects to be prefixed.
+		// Only the ones in the top package scope need to be prefixed.
+		scope := pkg.Types.Scope()
+		for _, name := range scope.Names() {
+			rename(scope.Lookup(name))
+		}
 	}
 
-	// Prefix the objects.
-	for id, obj := range info.Defs {
-		if objsToUpdate[obj] {
-			renameID(id, prefix+obj.Name())
+	// Prefix the objects once they are all known, as they may be used by other packages.
+	for _, pkg := range pkgs {
+		info := pkg.TypesInfo
+		for id, obj := range info.Defs {
+			if objsToUpdate[obj] {
+				renameID(id, prefix(obj.Pkg())+obj.Name())
+			}
 		}
-	}
-	for id, obj := range info.Uses {
-		if objsToUpdate[obj] {
-			renameID(id, prefix+obj.Name())
+		for id, obj := range info.Uses {
+			if objsToUpdate[obj] {
+				renameID(id, prefix(obj.Pkg())+obj.Name())
+			}
 		}
 	}
 }
 
-func keysOf(m map[string]bool) []string {
-	s := make([]string, 0, len(m))
-	for k := range m {
-		s = append(s, k)
+// recvIdent returns the identifier of the receiver type name of the method,
+// or nil if fn is not a method. Generic receivers such as *T[K, V] are supported.
+func recvIdent(fn *ast.FuncDecl) *ast.Ident {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return nil
+	}
+	x := fn.Recv.List[0].Type
+	for {
+		switch t := x.(type) {
+		case *ast.Ident:
+			return t
+		case *ast.StarExpr:
+			x = t.X
+		case *ast.ParenExpr:
+			x = t.X
+		case *ast.IndexExpr:
+			x = t.X
+		case *ast.IndexListExpr:
+			x = t.X
+		default:
+			return nil
+		}
 	}
-	return s
 }
 
 // renamer is to be used when identifiers are renamed/prefixed etc in an ast tree.
@@ -104,7 +119,10 @@ func keysOf(m map[string]bool) []string {
 func renamer() (rename func(*ast.Ident, string), done func()) {
 	m := map[*ast.Ident]string{}
 	return func(id *ast.Ident, name string) {
-			m[id] = id.Name
+			if _, ok := m[id]; !ok {
+				// Only keep the original name.
+				m[id] = id.Name
+			}
 			id.Name = name
 		}, func() {
 			for id, name := range m {
@@ -113,6 +131,131 @@ func renamer() (rename func(*ast.Ident, string), done func()) {
 		}
 }
 
+// unqualify replaces the selector expressions of the package referring to the local packages
+// by their selected identifier.
+// It returns the handler to be called to restore the original expressions.
+func unqualify(pkg *packages.Package, local map[*types.Package]bool) (done func()) {
+	m := map[*ast.Ident]*ast.SelectorExpr{}
+	for _, f := range pkg.Syntax {
+		astutil.Apply(f, func(c *astutil.Cursor) bool {
+			sel, ok := c.Node().(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			x, ok := sel.X.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			if pn, ok := pkg.TypesInfo.Uses[x].(*types.PkgName); !ok || !local[pn.Imported()] {
+				return true
+			}
+			m[sel.Sel] = sel
+			c.Replace(sel.Sel)
+			return false
+		}, nil)
+	}
+	return func() {
+		if len(m) == 0 {
+			return
+		}
+		for _, f := range pkg.Syntax {
+			astutil.Apply(f, func(c *astutil.Cursor) bool {
+				if id, ok := c.Node().(*ast.Ident); ok {
+					if sel, ok := m[id]; ok {
+						c.Replace(sel)
+						return false
+					}
+				}
+				return true
+			}, nil)
+		}
+	}
+}
+
+// printLineDecl writes the declaration of the file f with a //line directive pointing to
+// its position in f, including all its comments so that lines match.
+// The directive is written after the doc comment, where gofmt moves directives, and
+// refers to the line of the declaration itself.
+// The file name in the directive is relative to dir, the output directory (default=working directory).
+func printLineDecl(out io.Writer, fset *token.FileSet, f *ast.File, decl ast.Decl, dir string) error {
+	restore, err := printDoc(out, decl)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	pos := fset.Position(decl.Pos())
+	fname := pos.Filename
+	if dir, err := filepath.Abs(dir); err == nil {
+		if rel, err := filepath.Rel(dir, fname); err == nil {
+			fname = filepath.ToSlash(rel)
+		}
+	}
+	if _, err := fmt.Fprintf(out, "//line %s:%d\n", fname, pos.Line); err != nil {
+		return err
+	}
+	// Directives following code on the same line are removed, the other comments
+	// being kept so that lines match.
+	file := fset.File(decl.Pos())
+	ends := map[int]token.Pos{} // Line to the end of the code on that line
+	ast.Inspect(decl, func(n ast.Node) bool {
+		if n != nil {
+			if line := file.Line(n.End()); n.End() > ends[line] {
+				ends[line] = n.End()
+			}
+		}
+		return true
+	})
+	var comments []*ast.CommentGroup
+	for _, cg := range f.Comments {
+		if cg.Pos() < decl.Pos() || decl.End() < cg.End() {
+			continue
+		}
+		var list []*ast.Comment
+		for _, c := range cg.List {
+			if end, ok := ends[file.Line(c.Pos())]; !ok || end > c.Pos() || !isDirective(c) {
+				list = append(list, c)
+			}
+		}
+		switch len(list) {
+		case len(cg.List):
+			comments = append(comments, cg)
+		case 0:
+		default:
+			comments = append(comments, &ast.CommentGroup{List: list})
+		}
+	}
+	err = format.Node(out, fset, &printer.CommentedNode{Node: decl, Comments: comments})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(out, "\n")
+	return err
+}
+
+// printDoc writes the doc comment of the declaration and unsets it until restore is called,
+// so that the declaration is printed right after it, regardless of the lines of the comments
+// removed from it.
+func printDoc(out io.Writer, decl ast.Decl) (restore func(), err error) {
+	var doc **ast.CommentGroup
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		doc = &d.Doc
+	case *ast.GenDecl:
+		doc = &d.Doc
+	}
+	if doc == nil || *doc == nil {
+		return func() {}, nil
+	}
+	for _, c := range (*doc).List {
+		if _, err := fmt.Fprintf(out, "%s\n", c.Text); err != nil {
+			return nil, err
+		}
+	}
+	cg := *doc
+	*doc = nil
+	return func() { *doc = cg }, nil
+}
+
 func printNode(out io.Writer, fset *token.FileSet, node interface{}) error {
 	err := format.Node(out, fset, &printer.CommentedNode{Node: node})
 	if err != nil {
@@ -0,0 +1,249 @@
+package packagen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os/exec"
+	"strings"
+)
+
+// PluginPrefix is the prefix of the executables implementing the plugins.
+const PluginPrefix = "packagen-gen-"
+
+// PluginRequest is the JSON document written to the standard input of a plugin.
+type PluginRequest struct {
+	Param    string          // Parameter given to the plugin
+	Tests    bool            // Only the declarations of the test files are written
+	Packages []PluginPackage // Bundled packages
+}
+
+// PluginPackage describes a package.
+type PluginPackage struct {
+	Path    string         // Import path
+	Name    string         // Package name
+	Files   []PluginFile   // Go files
+	Objects []PluginObject // Package level objects and methods
+}
+
+// PluginFile describes a Go file.
+type PluginFile struct {
+	Name  string       // File name
+	Decls []PluginDecl // Top level declarations, imports excluded
+}
+
+// PluginDecl describes a top level declaration.
+type PluginDecl struct {
+	ID    int      // Identifier of the declaration in the request
+	Kind  string   // const, var, type, func or method
+	Names []string // Declared names, prefixed with the receiver type name for methods (e.g. T.Method)
+	Pos   string   // Position of the declaration: file:line:column
+	End   string   // Position of the end of the declaration
+}
+
+// PluginObject describes a package level object or a method.
+type PluginObject struct {
+	Name    string // Name, prefixed with the receiver type name for methods (e.g. T.Method)
+	NewName string `json:",omitempty"` // Name in the output if renamed
+	Kind    string // const, var, type, func or method
+	Type    string // Type of the object, its underlying type for type names
+}
+
+// PluginResponse is the JSON document read from the standard output of a plugin.
+type PluginResponse struct {
+	Error  string         `json:",omitempty"` // Error message, aborting the bundling
+	Rename []PluginRename `json:",omitempty"` // Objects to be renamed
+	Remove []int          `json:",omitempty"` // Identifiers of the declarations to be removed
+}
+
+// PluginRename renames an object and all its references.
+type PluginRename struct {
+	Path    string // Import path of the object package
+	Name    string // Object name, prefixed with the receiver type name for methods (e.g. T.Method)
+	NewName string // New name
+}
+
+// Plugin returns the Transformer running the packagen-gen-<name> executable found in PATH.
+// The executable receives the description of the packages as a PluginRequest on its standard input
+// and writes the edits to be applied as a PluginResponse to its standard output.
+// Anything written to its standard error is reported in case of failure.
+func Plugin(name, param string) Transformer {
+	return TransformerFunc(func(t *Transformation) error {
+		cmdName := PluginPrefix + name
+		path, err := exec.LookPath(cmdName)
+		if err != nil {
+			return fmt.Errorf("plugin %s: %v", name, err)
+		}
+		req, decls, objs := pluginRequest(t, param)
+		in, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		var out, stderr bytes.Buffer
+		cmd := exec.Command(path)
+		cmd.Stdin = bytes.NewReader(in)
+		cmd.Stdout = &out
+		cmd.Stderr = &stderr
+		t.Logf("Running plugin %s\n", path)
+		if err := cmd.Run(); err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return fmt.Errorf("plugin %s: %v: %s", name, err, msg)
+			}
+			return fmt.Errorf("plugin %s: %v", name, err)
+		}
+		var resp PluginResponse
+		if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+			return fmt.Errorf("plugin %s: invalid response: %v", name, err)
+		}
+		if resp.Error != "" {
+			return fmt.Errorf("plugin %s: %s", name, resp.Error)
+		}
+
+		for _, id := range resp.Remove {
+			if id < 0 || id >= len(decls) {
+				return fmt.Errorf("plugin %s: invalid declaration %d", name, id)
+			}
+			t.Remove(decls[id])
+		}
+		renames := map[types.Object]string{}
+		for _, r := range resp.Rename {
+			obj, ok := objs[r.Path+"."+r.Name]
+			if !ok {
+				return fmt.Errorf("plugin %s: %s.%s not found", name, r.Path, r.Name)
+			}
+			if !token.IsIdentifier(r.NewName) {
+				return fmt.Errorf("plugin %s: invalid name %q", name, r.NewName)
+			}
+			renames[obj] = r.NewName
+		}
+		if len(renames) == 0 {
+			return nil
+		}
+		for _, pkg := range t.Pkgs {
+			for id, obj := range pkg.TypesInfo.Defs {
+				if newname, ok := renames[obj]; ok {
+					t.Rename(id, newname)
+				}
+			}
+			for id, obj := range pkg.TypesInfo.Uses {
+				if newname, ok := renames[obj]; ok {
+					t.Rename(id, newname)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// pluginRequest returns the description of the packages, the declarations indexed by
+// their identifier and the objects keyed by import path and name.
+func pluginRequest(t *Transformation, param string) (*PluginRequest, []ast.Decl, map[string]types.Object) {
+	req := &PluginRequest{Param: param, Tests: t.Tests}
+	var decls []ast.Decl
+	objs := map[string]types.Object{}
+	for _, pkg := range t.Pkgs {
+		p := PluginPackage{Path: pkg.PkgPath, Name: pkg.Name}
+		info := pkg.TypesInfo
+		qualifier := types.RelativeTo(pkg.Types)
+		addObj := func(name string, obj types.Object) {
+			o := PluginObject{Name: name, Kind: objKind(obj), Type: types.TypeString(obj.Type().Underlying(), qualifier)}
+			if _, ok := obj.(*types.TypeName); !ok {
+				o.Type = types.TypeString(obj.Type(), qualifier)
+			}
+			if newname, ok := t.NewName(obj); ok {
+				o.NewName = newname
+			}
+			objs[pkg.PkgPath+"."+name] = obj
+			p.Objects = append(p.Objects, o)
+		}
+		scope := pkg.Types.Scope()
+		for _, name := range scope.Names() {
+			obj := scope.Lookup(name)
+			addObj(name, obj)
+			if tn, ok := obj.(*types.TypeName); ok {
+				if named, ok := tn.Type().(*types.Named); ok && !tn.IsAlias() {
+					for i := 0; i < named.NumMethods(); i++ {
+						m := named.Method(i)
+						addObj(name+"."+m.Name(), m)
+					}
+				}
+			}
+		}
+
+		for _, f := range pkg.Syntax {
+			pf := PluginFile{Name: pkg.Fset.File(f.Pos()).Name()}
+			for _, decl := range f.Decls {
+				if t.Removed(decl) {
+					continue
+				}
+				d := PluginDecl{
+					ID:  len(decls),
+					Pos: pkg.Fset.Position(decl.Pos()).String(),
+					End: pkg.Fset.Position(decl.End()).String(),
+				}
+				switch decl := decl.(type) {
+				case *ast.FuncDecl:
+					d.Kind = "func"
+					name := decl.Name.Name
+					if obj := info.Defs[decl.Name]; obj != nil {
+						name = obj.Name()
+						if tn := recvTypeName(obj); tn != nil {
+							d.Kind = "method"
+							name = tn.Name() + "." + name
+						}
+					}
+					d.Names = []string{name}
+				case *ast.GenDecl:
+					if decl.Tok == token.IMPORT {
+						continue
+					}
+					d.Kind = decl.Tok.String()
+					for _, spec := range decl.Specs {
+						switch spec := spec.(type) {
+						case *ast.TypeSpec:
+							d.Names = append(d.Names, objName(info, spec.Name))
+						case *ast.ValueSpec:
+							for _, id := range spec.Names {
+								d.Names = append(d.Names, objName(info, id))
+							}
+						}
+					}
+				}
+				decls = append(decls, decl)
+				pf.Decls = append(pf.Decls, d)
+			}
+			p.Files = append(p.Files, pf)
+		}
+		req.Packages = append(req.Packages, p)
+	}
+	return req, decls, objs
+}
+
+// objName returns the original name of the object defined by id.
+func objName(info *types.Info, id *ast.Ident) string {
+	if obj := info.Defs[id]; obj != nil {
+		return obj.Name()
+	}
+	return id.Name
+}
+
+// objKind returns the kind of the object as used in plugin requests.
+func objKind(obj types.Object) string {
+	switch obj := obj.(type) {
+	case *types.Const:
+		return "const"
+	case *types.Var:
+		return "var"
+	case *types.TypeName:
+		return "type"
+	case *types.Func:
+		if recvTypeName(obj) != nil {
+			return "method"
+		}
+		return "func"
+	}
+	return ""
+}
@@ -0,0 +1,49 @@
+package packagen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestPlugin(t *testing.T) {
+	c := qt.New(t)
+
+	dir := t.TempDir()
+	cmd := exec.Command("go", "build", "-o", filepath.Join(dir, PluginPrefix+"test"), "./testdata/plugin")
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, qt.IsNil, qt.Commentf("%s", out))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	buf := new(bytes.Buffer)
+	err = Bundle(buf, BundleOption{
+		Pkg:          "./testdata/bundle",
+		NewPkg:       "plugin",
+		Prefix:       "prefix",
+		Transformers: []Transformer{Plugin("test", "X")},
+	})
+	c.Assert(err, qt.IsNil)
+
+	fname := filepath.Join("testdata", "bundle_plugin.golden")
+	if *update {
+		t.Log("update golden file")
+		if err := ioutil.WriteFile(fname, buf.Bytes(), 0644); err != nil {
+			t.Fatalf("failed to update golden file: %s", err)
+		}
+	}
+	result, err := ioutil.ReadFile(fname)
+	c.Assert(err, qt.IsNil)
+	c.Assert(buf.String(), qt.Equals, string(result))
+
+	err = Bundle(new(bytes.Buffer), BundleOption{
+		Pkg:          "./testdata/bundle",
+		NewPkg:       "plugin",
+		Transformers: []Transformer{Plugin("missing", "")},
+	})
+	c.Assert(err, qt.Not(qt.IsNil))
+}
@@ -0,0 +1,162 @@
+package packagen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// declNode is the declaration of an object.
+type declNode struct {
+	pkg  *packages.Package
+	node ast.Node
+}
+
+// reachable returns the objects declared in the packages that are transitively used by the roots.
+// Methods of reachable types are considered reachable so that their method sets, and therefore
+// the interfaces they satisfy, are preserved.
+// The removed types and their methods are not followed.
+func reachable(pkgs []*packages.Package, roots, rmTypes map[string]bool) (map[types.Object]bool, error) {
+	decls := map[types.Object]declNode{}
+	methods := map[*types.TypeName][]types.Object{}
+	for _, pkg := range pkgs {
+		info := pkg.TypesInfo
+		for _, f := range pkg.Syntax {
+			for _, decl := range f.Decls {
+				switch decl := decl.(type) {
+				case *ast.FuncDecl:
+					obj := info.Defs[decl.Name]
+					if obj == nil {
+						continue
+					}
+					decls[obj] = declNode{pkg, decl}
+					if tn := recvTypeName(obj); tn != nil {
+						methods[tn] = append(methods[tn], obj)
+					}
+				case *ast.GenDecl:
+					for _, spec := range decl.Specs {
+						switch spec := spec.(type) {
+						case *ast.TypeSpec:
+							decls[info.Defs[spec.Name]] = declNode{pkg, spec}
+						case *ast.ValueSpec:
+							// Constants are kept by groups to preserve iota values.
+							var node ast.Node = spec
+							if decl.Tok == token.CONST {
+								node = decl
+							}
+							for _, id := range spec.Names {
+								if obj := info.Defs[id]; obj != nil {
+									decls[obj] = declNode{pkg, node}
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	live := map[types.Object]bool{}
+	var todo []types.Object
+	mark := func(obj types.Object) {
+		if live[obj] {
+			return
+		}
+		if _, ok := decls[obj]; !ok {
+			// Not declared in the packages.
+			return
+		}
+		if tn, ok := obj.(*types.TypeName); ok && rmTypes[tn.Name()] {
+			return
+		}
+		live[obj] = true
+		todo = append(todo, obj)
+	}
+
+	// Start with the roots.
+	names := make([]string, 0, len(roots))
+	for name := range roots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var found bool
+		for _, pkg := range pkgs {
+			if obj := pkg.Types.Scope().Lookup(name); obj != nil {
+				mark(obj)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("root %s not found", name)
+		}
+	}
+
+	for len(todo) > 0 {
+		obj := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if tn, ok := obj.(*types.TypeName); ok {
+			for _, m := range methods[tn] {
+				mark(m)
+			}
+		}
+		d := decls[obj]
+		ast.Inspect(d.node, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				if obj := d.pkg.TypesInfo.Uses[id]; obj != nil {
+					mark(obj)
+				} else if obj := d.pkg.TypesInfo.Defs[id]; obj != nil {
+					// Other constants of the same group.
+					mark(obj)
+				}
+			}
+			return true
+		})
+	}
+	return live, nil
+}
+
+// recvTypeName returns the receiver type name of the method or nil if obj is not a method.
+func recvTypeName(obj types.Object) *types.TypeName {
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return nil
+	}
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return nil
+	}
+	t := recv.Type()
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	if named, ok := t.(*types.Named); ok {
+		return named.Obj()
+	}
+	return nil
+}
+
+// liveSpecs returns the specs of the declaration that declare reachable objects.
+func liveSpecs(info *types.Info, decl *ast.GenDecl, live map[types.Object]bool) []ast.Spec {
+	var specs []ast.Spec
+	for _, spec := range decl.Specs {
+		switch s := spec.(type) {
+		case *ast.TypeSpec:
+			if live[info.Defs[s.Name]] {
+				specs = append(specs, spec)
+			}
+		case *ast.ValueSpec:
+			for _, id := range s.Names {
+				if live[info.Defs[id]] {
+					specs = append(specs, spec)
+					break
+				}
+			}
+		}
+	}
+	return specs
+}
@@ -0,0 +1,182 @@
+package packagen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"regexp"
+	"strings"
+)
+
+// RegexpPrefix is the prefix of the names to be removed that are regular expressions.
+const RegexpPrefix = "re:"
+
+// nameMatcher matches names against a set of names and regular expressions.
+type nameMatcher struct {
+	names map[string]bool
+	res   []*regexp.Regexp
+}
+
+// newNameMatcher returns the matcher for the names, the ones starting with RegexpPrefix being
+// regular expressions.
+func newNameMatcher(names map[string]bool) (*nameMatcher, error) {
+	m := &nameMatcher{names: map[string]bool{}}
+	for name, ok := range names {
+		if !ok {
+			continue
+		}
+		if !strings.HasPrefix(name, RegexpPrefix) {
+			m.names[name] = true
+			continue
+		}
+		re, err := regexp.Compile(strings.TrimPrefix(name, RegexpPrefix))
+		if err != nil {
+			return nil, err
+		}
+		m.res = append(m.res, re)
+	}
+	return m, nil
+}
+
+// empty reports whether the matcher never matches.
+func (m *nameMatcher) empty() bool {
+	return len(m.names) == 0 && len(m.res) == 0
+}
+
+func (m *nameMatcher) match(name string) bool {
+	if m.names[name] {
+		return true
+	}
+	for _, re := range m.res {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveDecls returns the Transformer removing the functions, the variables and the methods
+// (named Type.Method) whose name is in the corresponding set or matches one of its regular
+// expressions, prefixed with RegexpPrefix (e.g. re:^debug).
+// Bundling fails if a removed declaration is still referenced by the written code.
+func RemoveDecls(funcs, vars, methods map[string]bool) Transformer {
+	return TransformerFunc(func(t *Transformation) error {
+		fm, err := newNameMatcher(funcs)
+		if err != nil {
+			return err
+		}
+		vm, err := newNameMatcher(vars)
+		if err != nil {
+			return err
+		}
+		mm, err := newNameMatcher(methods)
+		if err != nil {
+			return err
+		}
+		if fm.empty() && vm.empty() && mm.empty() {
+			return nil
+		}
+		for _, pkg := range t.Pkgs {
+			info := pkg.TypesInfo
+			for _, f := range pkg.Syntax {
+				for _, decl := range f.Decls {
+					switch decl := decl.(type) {
+					case *ast.FuncDecl:
+						obj := info.Defs[decl.Name]
+						if obj == nil {
+							continue
+						}
+						if tn := recvTypeName(obj); tn != nil {
+							if name := tn.Name() + "." + obj.Name(); mm.match(name) {
+								t.Logf("method %s discarded", name)
+								t.removeObj(decl, obj)
+							}
+						} else if name := obj.Name(); fm.match(name) {
+							t.Logf("func %s discarded", name)
+							t.removeObj(decl, obj)
+						}
+					case *ast.GenDecl:
+						if decl.Tok == token.VAR && !vm.empty() {
+							removeVars(t, info, decl, vm)
+						}
+					}
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// removeVars removes the variables of the declaration matching m.
+// Variables declared along kept ones in the same spec are renamed to _ instead,
+// so that the values are still evaluated.
+func removeVars(t *Transformation, info *types.Info, decl *ast.GenDecl, m *nameMatcher) {
+	var specs []ast.Spec
+	for _, spec := range decl.Specs {
+		v := spec.(*ast.ValueSpec)
+		var removed int
+		for _, id := range v.Names {
+			obj := info.Defs[id]
+			if obj == nil || !m.match(obj.Name()) {
+				continue
+			}
+			removed++
+			t.removeObj(nil, obj)
+			t.Logf("var %s discarded", obj.Name())
+		}
+		switch {
+		case removed == 0:
+			specs = append(specs, spec)
+		case removed < len(v.Names) || len(v.Values) > 0 && len(v.Names) != len(v.Values):
+			// The spec is kept for the other variables, or the side effects of a multi-valued expression.
+			for _, id := range v.Names {
+				if t.rmObjs[info.Defs[id]] {
+					t.Rename(id, "_")
+				}
+			}
+			specs = append(specs, spec)
+		}
+	}
+	switch {
+	case len(specs) == 0:
+		t.Remove(decl)
+	case len(specs) < len(decl.Specs):
+		t.Defer(func(specs []ast.Spec, lparen token.Pos) func() {
+			return func() { decl.Specs, decl.Lparen = specs, lparen }
+		}(decl.Specs, decl.Lparen))
+		decl.Specs = specs
+		if len(specs) == 1 {
+			decl.Lparen = token.NoPos
+		}
+	}
+}
+
+// removeObj removes the declaration of obj, if any, and prevents obj from being prefixed.
+func (t *Transformation) removeObj(decl ast.Decl, obj types.Object) {
+	t.rmObjs[obj] = true
+	t.Objs[obj] = false
+	if decl != nil {
+		t.Remove(decl)
+	}
+}
+
+// checkRemoved returns an error if the declaration refers to an object removed by RemoveDecls.
+func checkRemoved(t *Transformation, fset *token.FileSet, info *types.Info, decl ast.Decl) error {
+	if len(t.rmObjs) == 0 {
+		return nil
+	}
+	var err error
+	ast.Inspect(decl, func(n ast.Node) bool {
+		if err != nil {
+			return false
+		}
+		if id, ok := n.(*ast.Ident); ok {
+			if obj := info.Uses[id]; t.rmObjs[obj] {
+				err = fmt.Errorf("%v: removed %s %s still referenced", fset.Position(id.Pos()), objKind(obj), obj.Name())
+			}
+		}
+		return true
+	})
+	return err
+}
@@ -0,0 +1,247 @@
+package packagen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// rewriteRule is a gofmt -r style rule: the single lowercase letter identifiers of the pattern
+// are wildcards matching any value expression, their binding being used in the replacement.
+type rewriteRule struct {
+	pattern, replace ast.Expr
+}
+
+// parseRewrite parses the rule 'pattern -> replacement'.
+func parseRewrite(rule string) (*rewriteRule, error) {
+	f := strings.Split(rule, "->")
+	if len(f) != 2 {
+		return nil, fmt.Errorf("rewrite rule %q must be of the form 'pattern -> replacement'", rule)
+	}
+	pattern, err := parser.ParseExpr(f[0])
+	if err != nil {
+		return nil, fmt.Errorf("rewrite rule %q: %v", rule, err)
+	}
+	replace, err := parser.ParseExpr(f[1])
+	if err != nil {
+		return nil, fmt.Errorf("rewrite rule %q: %v", rule, err)
+	}
+	return &rewriteRule{pattern, replace}, nil
+}
+
+// RewriteRules returns the Transformer applying the gofmt -r style rules 'pattern -> replacement'
+// in turn to the packages, e.g. 'a.Less(b) -> a < b'.
+// The single lowercase letter identifiers of the pattern are wildcards matching any value
+// expression (not types), and the other identifiers match the identifiers with the same
+// name, once renamed: the rules are to be applied after the built-in transformations.
+func RewriteRules(rules []string) Transformer {
+	return TransformerFunc(func(t *Transformation) error {
+		for _, s := range rules {
+			rule, err := parseRewrite(s)
+			if err != nil {
+				return err
+			}
+			for _, pkg := range t.Pkgs {
+				for _, f := range pkg.Syntax {
+					t.Defer(rule.apply(f, pkg.TypesInfo))
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// apply rewrites the matching expressions of the file, innermost first.
+// It returns the handler to be called to restore the original expressions.
+func (r *rewriteRule) apply(f *ast.File, info *types.Info) (done func()) {
+	replaced := map[ast.Node]ast.Node{}
+	pattern := reflect.ValueOf(r.pattern)
+	astutil.Apply(f, nil, func(c *astutil.Cursor) bool {
+		x, ok := c.Node().(ast.Expr)
+		if !ok {
+			return true
+		}
+		m := map[string]reflect.Value{}
+		if !r.match(info, m, pattern, reflect.ValueOf(x)) {
+			return true
+		}
+		y := subst(m, reflect.ValueOf(r.replace), reflect.ValueOf(x.Pos())).Interface().(ast.Expr)
+		replaced[y] = x
+		c.Replace(y)
+		return true
+	})
+
+	// The replaced expressions may contain replaced ones.
+	var restore func(n ast.Node) ast.Node
+	restore = func(n ast.Node) ast.Node {
+		return astutil.Apply(n, func(c *astutil.Cursor) bool {
+			if x, ok := replaced[c.Node()]; ok {
+				c.Replace(restore(x))
+				return false
+			}
+			return true
+		}, nil)
+	}
+	return func() {
+		if len(replaced) > 0 {
+			restore(f)
+		}
+	}
+}
+
+var (
+	identType     = reflect.TypeOf((*ast.Ident)(nil))
+	objectPtrType = reflect.TypeOf((*ast.Object)(nil))
+	positionType  = reflect.TypeOf(token.NoPos)
+	callExprType  = reflect.TypeOf((*ast.CallExpr)(nil))
+)
+
+// isWildcard reports whether name is a wildcard of the rewrite rules.
+func isWildcard(name string) bool {
+	return len(name) == 1 && unicode.IsLower(rune(name[0]))
+}
+
+// match reports whether pattern matches val, recording the wildcard bindings in m.
+// Wildcards only match value expressions.
+func (r *rewriteRule) match(info *types.Info, m map[string]reflect.Value, pattern, val reflect.Value) bool {
+	if m != nil && pattern.IsValid() && pattern.Type() == identType {
+		name := pattern.Interface().(*ast.Ident).Name
+		if isWildcard(name) && val.IsValid() {
+			if x, ok := val.Interface().(ast.Expr); ok && !val.IsNil() {
+				if tv, ok := info.Types[x]; ok && !tv.IsValue() {
+					// Types, packages and builtins are not bound.
+					return false
+				}
+				if old, ok := m[name]; ok {
+					// The wildcard must always match the same expression.
+					return r.match(info, nil, old, val)
+				}
+				m[name] = val
+				return true
+			}
+		}
+	}
+	if !pattern.IsValid() || !val.IsValid() {
+		return !pattern.IsValid() && !val.IsValid()
+	}
+	if pattern.Type() != val.Type() {
+		return false
+	}
+	switch pattern.Type() {
+	case identType:
+		if pattern.IsNil() || val.IsNil() {
+			return pattern.IsNil() && val.IsNil()
+		}
+		return pattern.Interface().(*ast.Ident).Name == val.Interface().(*ast.Ident).Name
+	case objectPtrType, positionType:
+		return true
+	case callExprType:
+		// The Ellipsis position is only relevant for its presence.
+		p := pattern.Interface().(*ast.CallExpr)
+		v := val.Interface().(*ast.CallExpr)
+		if p.Ellipsis.IsValid() != v.Ellipsis.IsValid() {
+			return false
+		}
+	}
+
+	p := reflect.Indirect(pattern)
+	v := reflect.Indirect(val)
+	if !p.IsValid() || !v.IsValid() {
+		return !p.IsValid() && !v.IsValid()
+	}
+	switch p.Kind() {
+	case reflect.Slice:
+		if p.Len() != v.Len() {
+			return false
+		}
+		for i := 0; i < p.Len(); i++ {
+			if !r.match(info, m, p.Index(i), v.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < p.NumField(); i++ {
+			if !r.match(info, m, p.Field(i), v.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Interface:
+		return r.match(info, m, p.Elem(), v.Elem())
+	}
+	return p.Interface() == v.Interface()
+}
+
+// subst returns a copy of pattern with the wildcards replaced by their binding in m,
+// and the positions set to pos if valid.
+// The bound expressions are reused, keeping their type information so that the declarations
+// they refer to are still seen as used, and copied when their wildcard occurs again.
+func subst(m map[string]reflect.Value, pattern, pos reflect.Value) reflect.Value {
+	if !pattern.IsValid() {
+		return reflect.Value{}
+	}
+	if m != nil && pattern.Type() == identType {
+		name := pattern.Interface().(*ast.Ident).Name
+		if old, ok := m[name]; ok {
+			// The copy keeps the positions of the bound expression.
+			m[name] = subst(nil, old, reflect.Value{})
+			return old
+		}
+	}
+	if pos.IsValid() && pattern.Type() == positionType {
+		// Keep the absence of positions (e.g. Ellipsis).
+		if p := pattern.Interface().(token.Pos); p.IsValid() {
+			return pos
+		}
+		return pattern
+	}
+
+	switch p := pattern; p.Kind() {
+	case reflect.Slice:
+		if p.IsNil() {
+			return reflect.Zero(p.Type())
+		}
+		v := reflect.MakeSlice(p.Type(), p.Len(), p.Len())
+		for i := 0; i < p.Len(); i++ {
+			v.Index(i).Set(subst(m, p.Index(i), pos))
+		}
+		return v
+	case reflect.Struct:
+		v := reflect.New(p.Type()).Elem()
+		for i := 0; i < p.NumField(); i++ {
+			v.Field(i).Set(subst(m, p.Field(i), pos))
+		}
+		return v
+	case reflect.Ptr:
+		if p.IsNil() {
+			return reflect.Zero(p.Type())
+		}
+		if p.Type() == objectPtrType {
+			// Do not copy the deprecated identifier objects.
+			return p
+		}
+		v := reflect.New(p.Type().Elem())
+		if elem := subst(m, p.Elem(), pos); elem.IsValid() {
+			v.Elem().Set(elem)
+		}
+		return v
+	case reflect.Interface:
+		if p.IsNil() {
+			return reflect.Zero(p.Type())
+		}
+		v := reflect.New(p.Type()).Elem()
+		if elem := subst(m, p.Elem(), pos); elem.IsValid() {
+			v.Set(elem)
+		}
+		return v
+	}
+	return pattern
+}
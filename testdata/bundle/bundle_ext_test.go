@@ -0,0 +1,25 @@
+package bundle_test
+
+import (
+	"testing"
+
+	"github.com/pierrec/packagen/testdata/bundle"
+)
+
+func TestAS(t *testing.T) {
+	as := bundle.AS{V: bundle.S{V: bundle.CA}}
+	if got, want := as.String(), "xyz"; got != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
+
+func BenchmarkAS(b *testing.B) {
+	var as bundle.AS
+	for i := 0; i < b.N; i++ {
+		_ = as.String()
+	}
+}
+
+func TestMain(m *testing.M) {
+	m.Run()
+}
@@ -0,0 +1,13 @@
+package bundle
+
+import "testing"
+
+func newS(v A) S {
+	return S{V: v}
+}
+
+func TestS(t *testing.T) {
+	if got, want := newS(C).String(), "abc"; got != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
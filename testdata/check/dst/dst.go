@@ -0,0 +1,7 @@
+package dst
+
+type Num int
+
+type Pair struct {
+	a, b int
+}
@@ -0,0 +1,10 @@
+package tmpl
+
+type Item int
+
+func Max(a, b Item) Item {
+	if a < b {
+		return b
+	}
+	return a
+}
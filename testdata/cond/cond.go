@@ -0,0 +1,38 @@
+package cond
+
+import "strings"
+
+// Item is the type of the sorted values.
+//
+//packagen:pivot
+type Item string
+
+// Size is the initial capacity.
+//
+//packagen:const
+const Size = 8
+
+// Less reports whether a sorts before b.
+func Less(a, b Item) bool {
+	//packagen:if Item=string
+	return strings.Compare(string(a), string(b)) < 0
+	//packagen:else
+	return a < b
+	//packagen:end
+}
+
+// New returns an empty list.
+func New() []Item {
+	//packagen:if Size!=0
+	return make([]Item, 0, Size)
+	//packagen:else
+	return nil
+	//packagen:end
+}
+
+//packagen:if Item!=string
+
+// Key returns the value as a key.
+func Key(a Item) Item { return a }
+
+//packagen:end
@@ -0,0 +1,25 @@
+package consts
+
+type Size int
+
+const BlockSize = 64
+
+const (
+	Ratio = 0.5
+	Name  = "abc"
+)
+
+const (
+	A Size = iota
+	B
+	C
+)
+
+const (
+	Max   Size = BlockSize * 2
+	Small int8 = 1
+)
+
+func (s Size) Blocks() int {
+	return int(s) / BlockSize
+}
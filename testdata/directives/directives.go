@@ -0,0 +1,56 @@
+package directives
+
+import "fmt"
+
+// Item is the type of the buffered values.
+//
+//packagen:pivot
+type Item int
+
+// Size is the number of buffered values.
+//
+//packagen:const
+const Size = 16
+
+// Version is the version of the template.
+const Version = 1
+
+const (
+	A = iota
+	B //packagen:remove
+	C
+)
+
+var (
+	zero  Item
+	debug = false //packagen:remove
+)
+
+// Buf buffers values.
+type Buf [Size]Item
+
+// Sum returns the sum of the buffered values.
+func (b *Buf) Sum() Item {
+	s := zero
+	for _, v := range b {
+		s += v
+	}
+	return s
+}
+
+// Max returns the largest value.
+//
+//packagen:keep
+func Max(a, b Item) Item {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+//packagen:remove
+func dump(b *Buf) {
+	if debug {
+		fmt.Println(b)
+	}
+}
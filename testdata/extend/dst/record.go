@@ -0,0 +1,25 @@
+package dst
+
+type Info struct {
+	tags []string
+}
+
+func (i *Info) Tags() []string {
+	return i.tags
+}
+
+type ExRecord struct {
+	count int
+}
+
+type ExTagged struct {
+	count int
+}
+
+func (t *ExTagged) Tags() []string {
+	return nil
+}
+
+type ExInfo struct {
+	Info
+}
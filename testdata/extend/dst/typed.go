@@ -0,0 +1,11 @@
+package dst
+
+import "strings"
+
+type ExTyped struct {
+	name string
+}
+
+func (t *ExTyped) Upper() string {
+	return strings.ToUpper(t.name)
+}
@@ -0,0 +1,25 @@
+package src
+
+// Base is flattened into the extended types.
+type Base struct {
+	id   int
+	name string
+}
+
+func (b *Base) ID() int {
+	return b.id
+}
+
+type Meta struct {
+	tags []string
+}
+
+type Record struct {
+	Base
+	*Meta
+	size int
+}
+
+func (r *Record) Size() int {
+	return r.size
+}
@@ -0,0 +1,20 @@
+package src
+
+import (
+	"bytes"
+	stdio "io"
+	"strings"
+)
+
+type Entry int
+
+type Typed struct {
+	m   map[string]int
+	p   *int
+	c   chan Entry
+	f   func(Entry) error
+	es  []Entry
+	buf bytes.Buffer
+	r   stdio.Reader
+	b   *strings.Builder
+}
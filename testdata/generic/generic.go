@@ -0,0 +1,55 @@
+package generic
+
+// Number is the constraint of the values.
+type Number interface {
+	~int | ~int64 | ~float64
+}
+
+// Set is a set of keys.
+type Set[K comparable] map[K]struct{}
+
+// Add adds the key to the set.
+func (s Set[K]) Add(k K) { s[k] = struct{}{} }
+
+// Has reports whether the key is in the set.
+func (s Set[K]) Has(k K) bool {
+	_, ok := s[k]
+	return ok
+}
+
+// Pair is a keyed value.
+type Pair[K comparable, V Number] struct {
+	Key   K
+	Value V
+}
+
+// Sum returns the sum of the values.
+func Sum[K comparable, V Number](pairs []Pair[K, V]) V {
+	var sum V
+	for _, p := range pairs {
+		sum += p.Value
+	}
+	return sum
+}
+
+// Keys returns the set of keys.
+func Keys[K comparable, V Number](pairs []Pair[K, V]) Set[K] {
+	s := make(Set[K])
+	for _, p := range pairs {
+		s.Add(p.Key)
+	}
+	return s
+}
+
+// Max returns the largest value.
+func Max[V Number](a, b V) V {
+	if a < b {
+		return b
+	}
+	return a
+}
+
+// Positive returns the value if positive, zero otherwise.
+func Positive[V Number](v V) V {
+	return Max(v, V(0))
+}
@@ -0,0 +1,7 @@
+package genericconflict
+
+// List is a list of values.
+type List[T any] []T
+
+// Names is instantiated with its own type argument.
+var Names List[string]
@@ -0,0 +1,89 @@
+package generify
+
+// Item is the pivot type.
+type Item int
+
+// Less reports whether a is less than b.
+func (a Item) Less(b Item) bool { return a < b }
+
+// Key identifies entries.
+type Key interface {
+	Hash() uint64
+}
+
+// Size is the default capacity.
+const Size = 16
+
+type (
+	// Items is a list of items.
+	Items []Item
+
+	// Entry is a keyed item.
+	Entry struct {
+		Key   Key
+		Value Item
+	}
+)
+
+func (s Items) Len() int           { return len(s) }
+func (s Items) Less(i, j int) bool { return s[i].Less(s[j]) }
+func (s *Items) Push(x Item)       { *s = append(*s, x) }
+
+// NewItems returns an empty list of items.
+func NewItems() Items {
+	return make(Items, 0, Size)
+}
+
+// Max returns the largest item.
+func Max(a, b Item) Item {
+	if a.Less(b) {
+		return b
+	}
+	return a
+}
+
+// Sum returns the sum of the items.
+func Sum(items Items) Item {
+	var sum Item
+	for _, x := range items {
+		sum += x
+	}
+	return sum + 1
+}
+
+// Count returns the number of occurrences of the items.
+func Count(items Items) map[Item]int {
+	m := map[Item]int{}
+	for _, x := range items {
+		m[x]++
+	}
+	return m
+}
+
+// Values returns the values of the entries.
+func Values(entries []Entry) Items {
+	s := NewItems()
+	for _, e := range entries {
+		s.Push(e.Value)
+	}
+	return s
+}
+
+// Find returns the index of the entry with the given key, or -1.
+func Find(entries []Entry, k Key) int {
+	for i, e := range entries {
+		if e.Key == k {
+			return i
+		}
+	}
+	return -1
+}
+
+// Hashes returns the hashes of the entries keys.
+func Hashes(entries []Entry) []uint64 {
+	var s []uint64
+	for _, e := range entries {
+		s = append(s, e.Key.Hash())
+	}
+	return s
+}
@@ -0,0 +1,17 @@
+package inline
+
+import (
+	"fmt"
+
+	"github.com/pierrec/packagen/testdata/inline/internal/util"
+)
+
+// Biggest returns the largest value of the pair.
+func Biggest(p util.Pair) int {
+	return util.Max(p.A, p.B)
+}
+
+// String returns the sum of the pair as a string.
+func String(p util.Pair) string {
+	return fmt.Sprint(p.Sum(), util.Zero)
+}
@@ -0,0 +1,4 @@
+package num
+
+// Add returns the sum of a and b.
+func Add(a, b int) int { return a + b }
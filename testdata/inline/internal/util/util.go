@@ -0,0 +1,22 @@
+package util
+
+import "github.com/pierrec/packagen/testdata/inline/internal/num"
+
+// Zero is the zero value.
+const Zero = 0
+
+// Pair holds two values.
+type Pair struct {
+	A, B int
+}
+
+// Sum returns the sum of the pair values.
+func (p Pair) Sum() int { return num.Add(p.A, p.B) }
+
+// Max returns the largest of a and b.
+func Max(a, b int) int {
+	if a < b {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,45 @@
+package modern
+
+// Item is the pivot type.
+type Item int
+
+// Less reports whether a is less than b.
+func (a *Item) Less(b Item) bool { return *a < b }
+
+// Set is a set of values.
+type Set[T comparable] struct {
+	m map[T]struct{}
+}
+
+// NewSet returns an empty set.
+func NewSet[T comparable]() *Set[T] {
+	return &Set[T]{m: map[T]struct{}{}}
+}
+
+// Add adds the value to the set.
+func (s *Set[T]) Add(v T) { s.m[v] = struct{}{} }
+
+// Len returns the number of values in the set.
+func (s *Set[_]) Len() int { return len(s.m) }
+
+// Pair holds two values.
+type Pair[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// Swap returns the pair with its fields swapped.
+func (p Pair[K, V]) Swap() Pair[K, K] { return Pair[K, K]{p.Key, p.Key} }
+
+// Items is a set of items.
+type Items = Set[Item]
+
+// Clamp restricts the item to the [lo, hi] range.
+func Clamp(v, lo, hi Item) Item {
+	return max(lo, min(v, hi))
+}
+
+// Any returns the value as an interface.
+func Any(v Item) any {
+	return v
+}
@@ -0,0 +1,8 @@
+package a
+
+import "github.com/pierrec/packagen/testdata/multi/b"
+
+// Double returns twice the value of v.
+func Double(v b.Value) b.Value {
+	return b.Add(v, v)
+}
@@ -0,0 +1,7 @@
+package b
+
+// Value is a number.
+type Value int
+
+// Add returns the sum of the values.
+func Add(x, y Value) Value { return x + y }
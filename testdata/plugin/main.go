@@ -0,0 +1,38 @@
+// Command packagen-gen-test is the plugin used by the tests: it renames the S type
+// to the plugin parameter and removes the GoString methods.
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/pierrec/packagen"
+)
+
+func main() {
+	var req packagen.PluginRequest
+	var resp packagen.PluginResponse
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		resp.Error = err.Error()
+	}
+	for _, pkg := range req.Packages {
+		for _, obj := range pkg.Objects {
+			if obj.Name == "S" {
+				resp.Rename = append(resp.Rename, packagen.PluginRename{
+					Path:    pkg.Path,
+					Name:    obj.Name,
+					NewName: req.Param,
+				})
+			}
+		}
+		for _, f := range pkg.Files {
+			for _, decl := range f.Decls {
+				if decl.Kind == "method" && strings.HasSuffix(decl.Names[0], ".GoString") {
+					resp.Remove = append(resp.Remove, decl.ID)
+				}
+			}
+		}
+	}
+	_ = json.NewEncoder(os.Stdout).Encode(resp)
+}
@@ -0,0 +1,10 @@
+package predeclared
+
+// Item is the type of the values.
+type Item int64
+
+// Max is the largest small value.
+const Max int = 10
+
+// Big reports whether the value is larger than Max.
+func Big(x Item) bool { return int(x) > Max }
@@ -0,0 +1,41 @@
+package remove
+
+import "fmt"
+
+var (
+	debugEnabled = false
+	count        int
+)
+
+var debugPrefix, name = "debug: ", "remove"
+
+// T is a counter.
+type T struct {
+	n int
+}
+
+// Inc increments the counter.
+func (t *T) Inc() {
+	t.n++
+	count++
+}
+
+// Dump prints the counter.
+func (t *T) Dump() {
+	debugf("%d", t.n)
+}
+
+func debugf(format string, args ...interface{}) {
+	if debugEnabled {
+		fmt.Printf(debugPrefix+format+"\n", args...)
+	}
+}
+
+func helper() string {
+	return name
+}
+
+// Name returns the name.
+func Name() string {
+	return helper()
+}
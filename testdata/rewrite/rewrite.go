@@ -0,0 +1,44 @@
+package rewrite
+
+import "bytes"
+
+// Item is the type of the sorted values.
+type Item int
+
+// Less reports whether i sorts before j.
+func (i Item) Less(j Item) bool { return i < j }
+
+// Key identifies the values.
+type Key []byte
+
+// Min returns the smallest item.
+func Min(items ...Item) Item {
+	m := items[0]
+	for _, it := range items[1:] {
+		if it.Less(m) {
+			m = it
+		}
+	}
+	return m
+}
+
+// Sorted reports whether the items are sorted.
+func Sorted(items []Item) bool {
+	for i := 1; i < len(items); i++ {
+		if items[i].Less(items[i-1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Same reports whether the keys are equal.
+func Same(a, b Key) bool {
+	return bytes.Equal(a, b)
+}
+
+// zero returns the zero item.
+func zero() Item { return 0 }
+
+// Neg reports whether the item is negative.
+func Neg(x Item) bool { return x.Less(zero()) }
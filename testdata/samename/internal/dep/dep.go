@@ -0,0 +1,9 @@
+package dep
+
+import "strconv"
+
+// Item has the name of a template type.
+type Item string
+
+// New returns the Item of the value.
+func New(v int) Item { return Item(strconv.Itoa(v)) }
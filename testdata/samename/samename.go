@@ -0,0 +1,11 @@
+package samename
+
+import "github.com/pierrec/packagen/testdata/samename/internal/dep"
+
+// Item is the type of the list elements.
+type Item int
+
+// Label returns the label of the item.
+func Label(x Item) dep.Item {
+	return dep.New(int(x))
+}
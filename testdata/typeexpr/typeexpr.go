@@ -0,0 +1,13 @@
+package typeexpr
+
+type Item int
+
+type List []Item
+
+func (l List) First() Item {
+	return Item(l[0])
+}
+
+func New(items ...Item) List {
+	return List(items)
+}
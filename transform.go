@@ -0,0 +1,293 @@
+package packagen
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Transformer modifies the AST of the bundled packages before their declarations are written.
+// The Transformers set in BundleOption are applied to every instance, after the built-in ones.
+type Transformer interface {
+	Transform(t *Transformation) error
+}
+
+// TransformerFunc adapts a function to the Transformer interface.
+type TransformerFunc func(t *Transformation) error
+
+// Transform calls f(t).
+func (f TransformerFunc) Transform(t *Transformation) error {
+	return f(t)
+}
+
+// Transformation is the state of the packages of an instance being bundled.
+// The packages are shared by all instances and subsequent calls to Bundle, so any
+// change to their AST must be undone once the instance is written: identifiers
+// are to be renamed with Rename, declarations removed with Remove and other changes
+// reverted by a handler registered with Defer.
+type Transformation struct {
+	Pkgs  []*packages.Package   // Bundled packages, with their syntax and type information
+	Tests bool                  // Only the declarations of the test files are written
+	Log   *log.Logger           // Logger, may be nil
+	Objs  map[types.Object]bool // Objects to be prefixed: set to false before prefixing to keep their name
+
+	prefix  func(*types.Package) string
+	rename  func(*ast.Ident, string)
+	names   map[types.Object]string // Renamed types
+	removed map[ast.Decl]bool
+	rmObjs  map[types.Object]bool // Objects removed by RemoveDecls
+	lines   bool                  // Declarations are written with their comments to keep their lines
+	restore []func()
+}
+
+// newTransformation returns the Transformation of the packages, their declarations
+// being prefixed by prefix.
+func newTransformation(pkgs []*packages.Package, prefix func(*types.Package) string,
+	logger *log.Logger, tests bool) *Transformation {
+	rename, done := renamer()
+	return &Transformation{
+		Pkgs:    pkgs,
+		Tests:   tests,
+		Log:     logger,
+		Objs:    map[types.Object]bool{},
+		prefix:  prefix,
+		rename:  rename,
+		names:   map[types.Object]string{},
+		removed: map[ast.Decl]bool{},
+		rmObjs:  map[types.Object]bool{},
+		restore: []func(){done},
+	}
+}
+
+// Rename renames the identifier until the instance is written.
+func (t *Transformation) Rename(id *ast.Ident, name string) {
+	t.rename(id, name)
+}
+
+// Prefix returns the prefix of the declarations of the package.
+func (t *Transformation) Prefix(pkg *types.Package) string {
+	return t.prefix(pkg)
+}
+
+// NewName returns the name the object is written with if it is renamed.
+func (t *Transformation) NewName(obj types.Object) (string, bool) {
+	if t.Objs[obj] {
+		return t.prefix(obj.Pkg()) + obj.Name(), true
+	}
+	name, ok := t.names[obj]
+	return name, ok
+}
+
+// Remove discards the top level declaration.
+func (t *Transformation) Remove(decl ast.Decl) {
+	t.removed[decl] = true
+}
+
+// Removed reports whether the top level declaration is discarded.
+func (t *Transformation) Removed(decl ast.Decl) bool {
+	return t.removed[decl]
+}
+
+// Defer registers f to be called once the instance is written.
+// The handlers are called in the reverse order of their registration.
+func (t *Transformation) Defer(f func()) {
+	t.restore = append(t.restore, f)
+}
+
+// Logf logs the message if a logger is set.
+func (t *Transformation) Logf(format string, args ...interface{}) {
+	if t.Log != nil {
+		t.Log.Printf(format, args...)
+	}
+}
+
+// done restores the packages.
+func (t *Transformation) done() {
+	for i := len(t.restore) - 1; i >= 0; i-- {
+		t.restore[i]()
+	}
+	t.restore = nil
+}
+
+// RenameTypes returns the Transformer renaming the types declared by the template package,
+// the first one, with the ones in names. The new names can be any type expression and are not prefixed, the declarations of the
+// types renamed to a type expression having to be removed, e.g. with RemoveTypes.
+// It must be applied before the declarations are prefixed.
+func RenameTypes(names map[string]string) Transformer {
+	return TransformerFunc(func(t *Transformation) error {
+		if len(names) == 0 {
+			return nil
+		}
+		// Inlined dependencies may declare types with the same names.
+		tmpl := t.Pkgs[0].PkgPath
+		rename := func(id *ast.Ident, obj types.Object) (string, bool) {
+			tn, ok := obj.(*types.TypeName)
+			if !ok || tn.Pkg() == nil || tn.Pkg().Path() != tmpl || tn.Parent() != tn.Pkg().Scope() {
+				return "", false
+			}
+			newname, ok := names[id.Name]
+			return newname, ok
+		}
+		for _, pkg := range t.Pkgs {
+			t.Logf("Renaming types in %v\n", pkg)
+			convs := conversions(pkg)
+			info := pkg.TypesInfo
+			for id, obj := range info.Defs {
+				if newname, ok := rename(id, obj); ok {
+					t.Objs[obj] = false
+					t.names[obj] = newname
+					t.Rename(id, newname)
+				}
+			}
+			for id, obj := range info.Uses {
+				if newname, ok := rename(id, obj); ok {
+					t.Objs[obj] = false
+					t.names[obj] = newname
+					if convs[id] && needParens(newname) {
+						newname = "(" + newname + ")"
+					}
+					t.Rename(id, newname)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// RemoveTypes returns the Transformer removing the named types, their methods
+// and the constants of these types.
+// It must be applied before the declarations are prefixed.
+func RemoveTypes(names map[string]bool) Transformer {
+	return TransformerFunc(func(t *Transformation) error {
+		if len(names) == 0 {
+			return nil
+		}
+		for _, pkg := range t.Pkgs {
+			info := pkg.TypesInfo
+			keepNames(t, info, names)
+			for _, f := range pkg.Syntax {
+			next:
+				for _, decl := range f.Decls {
+					switch decl := decl.(type) {
+					case *ast.GenDecl:
+						for _, spec := range decl.Specs {
+							switch spec := spec.(type) {
+							case *ast.TypeSpec:
+								if name := spec.Name.Name; names[name] {
+									t.Logf("type %s discarded", name)
+									t.Remove(decl)
+									continue next
+								}
+							case *ast.ValueSpec:
+								if decl.Tok != token.CONST {
+									continue
+								}
+								// Typed constant: remove if its type is to be removed.
+								if id, ok := spec.Type.(*ast.Ident); ok && names[id.Name] {
+									t.Logf("const of type %s discarded", id.Name)
+									t.Remove(decl)
+									continue next
+								}
+							}
+						}
+					case *ast.FuncDecl:
+						if id := recvIdent(decl); id != nil && names[id.Name] {
+							t.Logf("method for type %s discarded", id.Name)
+							t.Remove(decl)
+						}
+					}
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// RemoveConsts returns the Transformer removing the named constants.
+// Constants declared along others in the same spec are renamed to _ instead,
+// so that the values of iota are preserved.
+// It must be applied before the declarations are prefixed.
+func RemoveConsts(names map[string]bool) Transformer {
+	return TransformerFunc(func(t *Transformation) error {
+		if len(names) == 0 {
+			return nil
+		}
+		for _, pkg := range t.Pkgs {
+			info := pkg.TypesInfo
+			keepNames(t, info, names)
+			for _, f := range pkg.Syntax {
+			next:
+				for _, decl := range f.Decls {
+					decl, ok := decl.(*ast.GenDecl)
+					if !ok || decl.Tok != token.CONST {
+						continue
+					}
+					for _, spec := range decl.Specs {
+						v := spec.(*ast.ValueSpec)
+						if len(v.Values) == 0 {
+							// initial values; or nil
+							continue
+						}
+						// Do not print out the constant if it is defined standalone.
+						if len(v.Names) == 1 {
+							if name := v.Names[0].Name; names[name] {
+								// Constant to be completely removed.
+								t.Logf("const %s discarded", name)
+								t.Remove(decl)
+								continue next
+							}
+							continue
+						}
+						// If more than one constant, ignore its line (might be part of iota?).
+						for _, id := range v.Names {
+							if name := id.Name; names[name] {
+								// Constant to be ignored.
+								t.Rename(id, "_")
+								t.Logf("const %s ignored", name)
+							}
+						}
+					}
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// keepNames prevents the objects with the given names from being prefixed.
+func keepNames(t *Transformation, info *types.Info, names map[string]bool) {
+	for id, obj := range info.Defs {
+		if names[id.Name] {
+			t.Objs[obj] = false
+		}
+	}
+	for id, obj := range info.Uses {
+		if names[id.Name] {
+			t.Objs[obj] = false
+		}
+	}
+}
+
+// UpdateConsts returns the Transformer updating the values of the constants with the given
+// Go constant expressions, keyed by constant name.
+// The expressions are type checked against the declared constant types and their identifiers
+// refer to the declarations of the packages, which are renamed accordingly.
+func UpdateConsts(values map[string]string) Transformer {
+	return TransformerFunc(func(t *Transformation) error {
+		done, err := setConsts(t.Pkgs, values, t.Log, func(pkg *packages.Package, info *types.Info) {
+			for id, obj := range info.Uses {
+				if newname, ok := t.NewName(obj); ok {
+					t.Rename(id, newname)
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		t.Defer(done)
+		return nil
+	})
+}
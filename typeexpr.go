@@ -0,0 +1,73 @@
+package packagen
+
+import (
+	"fmt"
+	"go/parser"
+	"strings"
+)
+
+// typeExpr converts s into a Go type expression.
+// Qualified identifiers in s may use the package import path instead of its name
+// (e.g. *example.com/x/y.Thing becomes *y.Thing) and the imports they require are
+// added to imports, keyed by import path.
+func typeExpr(s string, imports map[string]string) (string, error) {
+	isPathChar := func(c byte) bool {
+		return c == '_' || c == '.' || c == '/' || c == '-' ||
+			'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if !isPathChar(s[i]) {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(s) && isPathChar(s[j]) {
+			j++
+		}
+		word := s[i:j]
+		i = j
+		dot := strings.LastIndexByte(word, '.')
+		if dot < 0 {
+			b.WriteString(word)
+			continue
+		}
+		// Qualified identifier.
+		path, sel := word[:dot], word[dot+1:]
+		name, err := importName(path)
+		if err != nil {
+			return "", fmt.Errorf("type %s: %v", s, err)
+		}
+		imports[path] = name
+		b.WriteString(name)
+		b.WriteByte('.')
+		b.WriteString(sel)
+	}
+	expr := b.String()
+	if _, err := parser.ParseExpr(expr); err != nil {
+		return "", fmt.Errorf("type %s: invalid type expression: %v", s, err)
+	}
+	return expr, nil
+}
+
+// typeExprs converts the values of the types mapping into Go type expressions.
+func typeExprs(types map[string]string, imports map[string]string) (map[string]string, error) {
+	if len(types) == 0 {
+		return types, nil
+	}
+	m := make(map[string]string, len(types))
+	for name, s := range types {
+		expr, err := typeExpr(s, imports)
+		if err != nil {
+			return nil, err
+		}
+		m[name] = expr
+	}
+	return m, nil
+}
+
+// needParens reports whether the type expression needs to be parenthesized when used in a conversion.
+func needParens(expr string) bool {
+	return strings.HasPrefix(expr, "*") || strings.HasPrefix(expr, "<-") || strings.HasPrefix(expr, "func")
+}
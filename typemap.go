@@ -0,0 +1,256 @@
+package packagen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	pathpkg "path"
+	"sort"
+	"strconv"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+)
+
+// typeMapper maps the types of the fields copied from the source package to the destination file.
+// The new types are Go type expressions where packages can be referred to by their import path.
+type typeMapper struct {
+	pkg     *packages.Package // Source package
+	types   map[string]string // Type name, qualified by its import path if not from pkg, to the new type
+	dst     map[string]string // Imports of the destination file: import path to name
+	imports map[string]string // Imports to be added to the destination file: import path to name
+}
+
+// newTypeMapper returns the mapper of the fields of the source package to the destination file.
+func newTypeMapper(o ExtendOption, srcPkg, dstPkg *packages.Package, dstFile *ast.File) (*typeMapper, error) {
+	m := &typeMapper{
+		pkg:     srcPkg,
+		types:   o.Types,
+		dst:     map[string]string{},
+		imports: map[string]string{},
+	}
+	for _, spec := range dstFile.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return nil, err
+		}
+		name := pathpkg.Base(path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		} else if p := dstPkg.Imports[path]; p != nil {
+			name = p.Name
+		}
+		if name != "_" && name != "." {
+			m.dst[path] = name
+		}
+	}
+	return m, nil
+}
+
+// mapField maps the type of the named field: the type of its element, found by following
+// the pointer, slice, array, channel and map value types, is replaced by newtype wherever it
+// occurs, as well as the types of the types mapping.
+func (m *typeMapper) mapField(field *ast.Field, newtype string) (string, bool, error) {
+	elem := field.Type
+	for {
+		switch e := elem.(type) {
+		case *ast.ParenExpr:
+			elem = e.X
+			continue
+		case *ast.StarExpr:
+			elem = e.X
+			continue
+		case *ast.ArrayType:
+			elem = e.Elt
+			continue
+		case *ast.ChanType:
+			elem = e.Value
+			continue
+		case *ast.MapType:
+			elem = e.Value
+			continue
+		case *ast.IndexExpr:
+			elem = e.X
+		case *ast.IndexListExpr:
+			elem = e.X
+		}
+		break
+	}
+	var obj types.Object
+	switch e := elem.(type) {
+	case *ast.Ident:
+		obj = m.pkg.TypesInfo.Uses[e]
+	case *ast.SelectorExpr:
+		obj = m.pkg.TypesInfo.Uses[e.Sel]
+	}
+	if obj == nil {
+		return "", false, fmt.Errorf("field %s: no element type to be mapped in %s",
+			field.Names[0].Name, types.ExprString(field.Type))
+	}
+	return m.mapType(field.Type, obj, newtype)
+}
+
+// mapType returns the type expression with the types of the types mapping, and obj if not nil,
+// replaced by their new type and the qualified identifiers referring to the imports of the
+// destination file. It reports whether the expression changed.
+func (m *typeMapper) mapType(expr ast.Expr, obj types.Object, newtype string) (string, bool, error) {
+	info := m.pkg.TypesInfo
+	subst := func(id *ast.Ident) (string, bool, error) {
+		o, ok := info.Uses[id].(*types.TypeName)
+		if !ok {
+			return "", false, nil
+		}
+		s, ok := newtype, o == obj
+		if !ok {
+			key := o.Name()
+			if o.Pkg() != nil && o.Pkg() != m.pkg.Types {
+				key = o.Pkg().Path() + "." + key
+			}
+			if s, ok = m.types[key]; !ok {
+				return "", false, nil
+			}
+		}
+		s, err := m.typeExpr(s)
+		return s, err == nil, err
+	}
+	// New expressions of the identifiers and qualified identifiers, in depth first order.
+	var (
+		repl    []string
+		changed bool
+		err     error
+	)
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if err != nil {
+			return false
+		}
+		var s string
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			x, ok := n.X.(*ast.Ident)
+			if !ok {
+				break
+			}
+			pn, ok := info.Uses[x].(*types.PkgName)
+			if !ok {
+				break
+			}
+			var mapped bool
+			s, mapped, err = subst(n.Sel)
+			if !mapped && err == nil {
+				s, err = m.importName(pn.Imported())
+				s += "." + n.Sel.Name
+			}
+			changed = changed || s != types.ExprString(n)
+			repl = append(repl, s)
+			return false
+		case *ast.Ident:
+			var mapped bool
+			s, mapped, err = subst(n)
+			changed = changed || mapped
+		default:
+			return true
+		}
+		repl = append(repl, s)
+		return true
+	})
+	if err != nil || !changed {
+		return "", false, err
+	}
+	// Replace the nodes of a copy of the expression.
+	x, err := parser.ParseExpr(types.ExprString(expr))
+	if err != nil {
+		return "", false, err
+	}
+	var i int
+	node := astutil.Apply(x, func(c *astutil.Cursor) bool {
+		switch c.Node().(type) {
+		case *ast.SelectorExpr, *ast.Ident:
+			s := repl[i]
+			i++
+			if s != "" {
+				c.Replace(ast.NewIdent(s))
+				return false
+			}
+		}
+		return true
+	}, nil)
+	return types.ExprString(node.(ast.Expr)), true, nil
+}
+
+// typeExpr converts the new type s into a Go type expression, recording the imports it requires.
+func (m *typeMapper) typeExpr(s string) (string, error) {
+	imports := map[string]string{}
+	expr, err := typeExpr(s, imports)
+	if err != nil {
+		return "", err
+	}
+	for path, name := range imports {
+		if err := m.addImport(path, name); err != nil {
+			return "", err
+		}
+	}
+	return expr, nil
+}
+
+// importName returns the name the package is referred to in the destination file,
+// adding its import if required.
+func (m *typeMapper) importName(pkg *types.Package) (string, error) {
+	if name, ok := m.dst[pkg.Path()]; ok {
+		return name, nil
+	}
+	return pkg.Name(), m.addImport(pkg.Path(), pkg.Name())
+}
+
+// addImport records the import of the package with the given path and name, which
+// must not conflict with the imports of the destination file.
+func (m *typeMapper) addImport(path, name string) error {
+	if n, ok := m.dst[path]; ok {
+		if n != name {
+			return fmt.Errorf("package %s is imported as %s instead of %s", path, n, name)
+		}
+		return nil
+	}
+	for _, imports := range []map[string]string{m.dst, m.imports} {
+		for p, n := range imports {
+			if n == name && p != path {
+				return fmt.Errorf("package %s conflicts with the import of %s as %s", path, p, name)
+			}
+		}
+	}
+	m.imports[path] = name
+	return nil
+}
+
+// printImports prints the file with the imports, keyed by import path, added.
+func printImports(out io.Writer, fset *token.FileSet, f *ast.File, imports map[string]string) error {
+	if len(imports) == 0 {
+		return printNode(out, fset, f)
+	}
+	// Add the imports to a copy of the file, leaving the loaded one untouched.
+	var buf bytes.Buffer
+	if err := printNode(&buf, fset, f); err != nil {
+		return err
+	}
+	fset = token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", buf.Bytes(), parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(imports))
+	for path := range imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		name := imports[path]
+		if name == pathpkg.Base(path) {
+			name = ""
+		}
+		astutil.AddNamedImport(fset, f, name, path)
+	}
+	return printNode(out, fset, f)
+}